@@ -0,0 +1,126 @@
+package artifacts
+
+import (
+	"archive/tar"
+	"cheops/types"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrNotFound is returned when a build has no such artifact
+var ErrNotFound = errors.New("Artifact not found")
+
+// Store keeps the artifacts of every build in a directory, one
+// subdirectory per build
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &Store{dir: dir}, nil
+}
+
+// cleanName returns the relative path of an artifact, or an empty string if
+// it's outside of the artifacts of the build
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (s *Store) path(id, name string) string {
+	return filepath.Join(s.dir, filepath.Base(id), filepath.FromSlash(name))
+}
+
+// Extract unpacks a tar archive, as copied out of a container, into the
+// artifacts of a build and returns the files it holds. Links and special
+// files are skipped.
+func (s *Store) Extract(id string, archive io.Reader) ([]*types.ArtifactRecord, error) {
+	records := []*types.ArtifactRecord{}
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return records, err
+		}
+
+		name := cleanName(header.Name)
+		if name == "" {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(s.path(id, name), 0700); err != nil {
+				return records, err
+			}
+
+		case tar.TypeReg:
+			size, err := s.writeFile(s.path(id, name), reader)
+			if err != nil {
+				return records, err
+			}
+			records = append(records, &types.ArtifactRecord{Path: name, Size: size})
+
+		default:
+			log.WithFields(log.Fields{
+				"build":    id,
+				"artifact": name,
+			}).Debug("Skipping artifact that isn't a regular file")
+		}
+	}
+}
+
+func (s *Store) writeFile(filename string, content io.Reader) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return 0, err
+	}
+
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return size, err
+}
+
+// Open returns an artifact of a build
+func (s *Store) Open(id, name string) (*os.File, error) {
+	name = cleanName(name)
+	if name == "" {
+		return nil, ErrNotFound
+	}
+
+	file, err := os.Open(s.path(id, name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return nil, ErrNotFound
+	}
+	return file, nil
+}
+
+// Remove deletes the artifacts of a build
+func (s *Store) Remove(id string) error {
+	return os.RemoveAll(filepath.Join(s.dir, filepath.Base(id)))
+}
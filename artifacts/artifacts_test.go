@@ -0,0 +1,75 @@
+package artifacts
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewStore(filepath.Join(dir, "artifacts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.Buffer{}
+	writer := tar.NewWriter(&buf)
+	entries := []struct {
+		header  tar.Header
+		content string
+	}{
+		{tar.Header{Name: "dist/", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		{tar.Header{Name: "dist/app", Typeflag: tar.TypeReg, Mode: 0755}, "binary"},
+		{tar.Header{Name: "dist/latest", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}, ""},
+		{tar.Header{Name: "../../escape", Typeflag: tar.TypeReg, Mode: 0644}, "contained"},
+	}
+	for _, entry := range entries {
+		entry.header.Size = int64(len(entry.content))
+		writer.WriteHeader(&entry.header)
+		writer.Write([]byte(entry.content))
+	}
+	writer.Close()
+
+	records, err := store.Extract("1234", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Path != "dist/app" || records[0].Size != 6 || records[1].Path != "escape" {
+		t.Error("Wrong records:", records)
+	}
+
+	file, err := store.Open("1234", "/dist/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(file)
+	file.Close()
+	if string(content) != "binary" {
+		t.Error("Wrong content:", string(content))
+	}
+
+	for _, name := range []string{"dist", "dist/latest", "missing", ""} {
+		if _, err := store.Open("1234", name); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound for %q, got %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape")); !os.IsNotExist(err) {
+		t.Error("Archive escaped the artifacts directory")
+	}
+
+	if err := store.Remove("1234"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Open("1234", "dist/app"); err != ErrNotFound {
+		t.Error("Expected the artifacts to be removed, got", err)
+	}
+}
@@ -5,70 +5,251 @@ import (
 	"encoding/json"
 	"errors"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/docker/docker/api/types"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// expiryMargin is how long before its expiry a token is renewed
+const expiryMargin = 5 * time.Minute
+
+// defaultSessionName is the session name of assumed roles
+const defaultSessionName = "cheops"
+
+// Config configures the AWS session, the ECR registries and S3 uploads
+type Config struct {
+	Region string
+	// AccessKeyID, SecretAccessKey and SessionToken are static credentials,
+	// when empty the standard credential chain is used: environment,
+	// shared profile, web identity and instance role
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Profile         string
+	// RoleARN is assumed with ExternalID before calling ECR, e.g. to push
+	// to another account
+	RoleARN     string
+	ExternalID  string
+	SessionName string
+	// RegistryIDs are the accounts whose registries tokens are requested
+	// for, along with the account of the pushed image
+	RegistryIDs []string
+	// Endpoint and STSEndpoint override the ECR and STS endpoints
+	Endpoint    string
+	STSEndpoint string
+	// CreateRepositories creates missing repositories of the account of the
+	// credentials before pushing, with the tag mutability (MUTABLE or
+	// IMMUTABLE), scan on push and lifecycle policy JSON below
+	CreateRepositories bool
+	ImageTagMutability string
+	ScanOnPush         bool
+	LifecyclePolicy    string
+	// S3Endpoint overrides the S3 endpoint of uploads, e.g. for MinIO, which
+	// usually needs S3PathStyle addressing (endpoint/bucket/key)
+	S3Endpoint  string
+	S3PathStyle bool
+}
+
+type authorization struct {
+	username      string
+	password      string
+	serverAddress string
+	expiresAt     time.Time
+}
+
 type AWSDockerCredentialsProvider struct {
-	// Region             string
-	// AwsAccessKeyID     string
-	// AwsSecretAccessKey string
-	// AwsSessionToken    string
-	session *session.Session
+	ecr         ecriface.ECRAPI
+	sts         stsiface.STSAPI
+	registryIDs []string
+	config      *Config
+
+	mutex sync.Mutex
+	// authorizations are cached by registry host
+	authorizations map[string]*authorization
+	// repositories are the registry host/repository pairs known to exist
+	repositories map[string]bool
+	// accountID is the account of the credentials, once known
+	accountID string
 }
 
-func New(region, id, secret, token string) (*AWSDockerCredentialsProvider, error) {
-	log.WithFields(log.Fields{
-		"provider": "aws",
-	}).Debug("Initializing Docker credentials provider")
+// stsConfig returns the config of the STS clients
+func stsConfig(config *Config) *aws.Config {
+	stsConfig := aws.NewConfig()
+	if config.STSEndpoint != "" {
+		stsConfig = stsConfig.WithEndpoint(config.STSEndpoint)
+	}
+	return stsConfig
+}
 
-	if region == "" {
-		return nil, errors.New("Must specify an AWS Region")
+// NewSession creates an AWS session from the region, credentials and role
+// of config
+func NewSession(config *Config) (*session.Session, error) {
+	awsConfig := aws.NewConfig()
+	if config.Region != "" {
+		awsConfig = awsConfig.WithRegion(config.Region)
 	}
-	config := aws.NewConfig().WithRegion(region)
-	if id != "" {
-		config = config.WithCredentials(
-			credentials.NewStaticCredentials(id, secret, token),
+	if config.AccessKeyID != "" {
+		awsConfig = awsConfig.WithCredentials(
+			credentials.NewStaticCredentials(config.AccessKeyID, config.SecretAccessKey, config.SessionToken),
 		)
 	}
 
-	s, err := session.NewSession(config)
+	s, err := session.NewSessionWithOptions(session.Options{
+		Config:            *awsConfig,
+		Profile:           config.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &AWSDockerCredentialsProvider{s}, nil
+	if aws.StringValue(s.Config.Region) == "" {
+		return nil, errors.New("Must specify an AWS Region")
+	}
+
+	if config.RoleARN != "" {
+		sessionName := config.SessionName
+		if sessionName == "" {
+			sessionName = defaultSessionName
+		}
+
+		creds := stscreds.NewCredentialsWithClient(sts.New(s, stsConfig(config)), config.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = sessionName
+			if config.ExternalID != "" {
+				p.ExternalID = aws.String(config.ExternalID)
+			}
+		})
+		s = s.Copy(aws.NewConfig().WithCredentials(creds))
+	}
+
+	return s, nil
 }
 
-func (a *AWSDockerCredentialsProvider) GetCredentials() (string, error) {
-	svc := ecr.New(a.session)
-	out, err := svc.GetAuthorizationToken(&ecr.GetAuthorizationTokenInput{})
+func New(config *Config) (*AWSDockerCredentialsProvider, error) {
+	log.WithFields(log.Fields{
+		"provider": "aws",
+		"role":     config.RoleARN,
+	}).Debug("Initializing Docker credentials provider")
+
+	switch config.ImageTagMutability {
+	case "", ecr.ImageTagMutabilityMutable, ecr.ImageTagMutabilityImmutable:
+	default:
+		return nil, errors.New("Invalid image tag mutability: " + config.ImageTagMutability)
+	}
+
+	s, err := NewSession(config)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	ecrConfig := aws.NewConfig()
+	if config.Endpoint != "" {
+		ecrConfig = ecrConfig.WithEndpoint(config.Endpoint)
 	}
 
-	creds, err := base64.StdEncoding.DecodeString(*out.AuthorizationData[0].AuthorizationToken)
+	return &AWSDockerCredentialsProvider{
+		ecr:            ecr.New(s, ecrConfig),
+		sts:            sts.New(s, stsConfig(config)),
+		registryIDs:    config.RegistryIDs,
+		config:         config,
+		authorizations: map[string]*authorization{},
+		repositories:   map[string]bool{},
+	}, nil
+}
+
+// registryID returns the account ID of an ECR registry host
+func registryID(registry string) string {
+	return strings.SplitN(registry, ".", 2)[0]
+}
+
+// serverHost strips the scheme of a proxy endpoint
+func serverHost(endpoint string) string {
+	if i := strings.Index(endpoint, "://"); i != -1 {
+		return endpoint[i+3:]
+	}
+	return endpoint
+}
+
+// fetchAuthorizations requests tokens for the registry and the configured
+// registries, and caches them
+func (a *AWSDockerCredentialsProvider) fetchAuthorizations(registry string) error {
+	ids := []*string{aws.String(registryID(registry))}
+	for _, id := range a.registryIDs {
+		if id != registryID(registry) {
+			ids = append(ids, aws.String(id))
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"registries": aws.StringValueSlice(ids),
+	}).Debug("Requesting ECR authorization tokens")
+
+	out, err := a.ecr.GetAuthorizationToken(&ecr.GetAuthorizationTokenInput{
+		RegistryIds: ids,
+	})
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	for _, data := range out.AuthorizationData {
+		creds, err := base64.StdEncoding.DecodeString(aws.StringValue(data.AuthorizationToken))
+		if err != nil {
+			return err
+		}
+
+		splitCreds := strings.SplitN(string(creds), ":", 2)
+		if len(splitCreds) != 2 {
+			return errors.New("Invalid ECR authorization token")
+		}
+
+		endpoint := aws.StringValue(data.ProxyEndpoint)
+		a.authorizations[serverHost(endpoint)] = &authorization{
+			username:      splitCreds[0],
+			password:      splitCreds[1],
+			serverAddress: endpoint,
+			expiresAt:     aws.TimeValue(data.ExpiresAt),
+		}
 	}
 
-	splitCreds := strings.Split(string(creds), ":")
-	dockerCreds := struct {
-		Username string
-		Password string
-		Email    string
-	}{
-		Username: splitCreds[0],
-		Password: splitCreds[1],
-		Email:    "none",
+	return nil
+}
+
+func (a *AWSDockerCredentialsProvider) GetCredentials(registry string) (string, error) {
+	if !strings.Contains(registry, ".dkr.ecr.") {
+		return "", errors.New("Not an ECR registry: " + registry)
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	auth, ok := a.authorizations[registry]
+	if !ok || !time.Now().Add(expiryMargin).Before(auth.expiresAt) {
+		if err := a.fetchAuthorizations(registry); err != nil {
+			return "", err
+		}
+
+		auth, ok = a.authorizations[registry]
+		if !ok {
+			return "", errors.New("No ECR authorization for registry: " + registry)
+		}
 	}
 
-	bytes, err := json.Marshal(dockerCreds)
+	bytes, err := json.Marshal(types.AuthConfig{
+		Username:      auth.username,
+		Password:      auth.password,
+		ServerAddress: auth.serverAddress,
+	})
 	if err != nil {
 		return "", err
 	}
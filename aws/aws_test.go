@@ -0,0 +1,169 @@
+package aws
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	registry      = "123456789012.dkr.ecr.eu-west-1.amazonaws.com"
+	otherRegistry = "210987654321.dkr.ecr.eu-west-1.amazonaws.com"
+)
+
+type ecrRequest struct {
+	accessKey   string
+	registryIDs []string
+}
+
+// newFakeECR serves GetAuthorizationToken, returning a token per requested
+// registry that expires after expiresIn
+func newFakeECR(t *testing.T, expiresIn time.Duration) (*httptest.Server, *[]ecrRequest) {
+	requests := []ecrRequest{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); !strings.HasSuffix(target, ".GetAuthorizationToken") {
+			t.Error("Unexpected operation:", target)
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		input := struct {
+			RegistryIds []string `json:"registryIds"`
+		}{}
+		json.Unmarshal(body, &input)
+
+		auth := r.Header.Get("Authorization")
+		accessKey := auth[strings.Index(auth, "Credential=")+11:]
+		accessKey = accessKey[:strings.Index(accessKey, "/")]
+		requests = append(requests, ecrRequest{accessKey, input.RegistryIds})
+
+		data := []map[string]interface{}{}
+		for _, id := range input.RegistryIds {
+			token := base64.StdEncoding.EncodeToString([]byte("AWS:password-" + id))
+			data = append(data, map[string]interface{}{
+				"authorizationToken": token,
+				"expiresAt":          time.Now().Add(expiresIn).Unix(),
+				"proxyEndpoint":      "https://" + id + ".dkr.ecr.eu-west-1.amazonaws.com",
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		json.NewEncoder(w).Encode(map[string]interface{}{"authorizationData": data})
+	}))
+
+	return server, &requests
+}
+
+func testConfig(endpoint string) *Config {
+	return &Config{
+		Region:          "eu-west-1",
+		AccessKeyID:     "STATICKEY",
+		SecretAccessKey: "secret",
+		Endpoint:        endpoint,
+	}
+}
+
+func TestGetCredentials(t *testing.T) {
+	server, requests := newFakeECR(t, 12*time.Hour)
+	defer server.Close()
+
+	config := testConfig(server.URL)
+	config.RegistryIDs = []string{"210987654321"}
+	p, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := p.GetCredentials(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"username":"AWS","password":"password-123456789012","serveraddress":"https://` + registry + `"}`
+	if creds != expected {
+		t.Error("Wrong credentials:", creds)
+	}
+
+	// Cached from the first request
+	creds, err = p.GetCredentials(otherRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(creds, `"password":"password-210987654321"`) {
+		t.Error("Wrong credentials:", creds)
+	}
+	p.GetCredentials(registry)
+
+	if len(*requests) != 1 {
+		t.Fatal("Expected the tokens to be cached, got", len(*requests), "requests")
+	}
+	if ids := (*requests)[0].registryIDs; len(ids) != 2 || ids[0] != "123456789012" || ids[1] != "210987654321" {
+		t.Error("Wrong registry IDs:", ids)
+	}
+
+	if _, err := p.GetCredentials("docker.io"); err == nil {
+		t.Error("Expected an error for a non ECR registry")
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	server, requests := newFakeECR(t, time.Minute)
+	defer server.Close()
+
+	p, err := New(testConfig(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.GetCredentials(registry)
+	p.GetCredentials(registry)
+	if len(*requests) != 2 {
+		t.Error("Expected the expiring token to be renewed, got", len(*requests), "requests")
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+	ecrServer, requests := newFakeECR(t, 12*time.Hour)
+	defer ecrServer.Close()
+
+	stsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") != "AssumeRole" || r.Form.Get("RoleArn") != "arn:aws:iam::210987654321:role/pusher" ||
+			r.Form.Get("ExternalId") != "external" || r.Form.Get("RoleSessionName") != "cheops" {
+			t.Error("Wrong AssumeRole request:", r.Form)
+		}
+
+		fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASSUMEDKEY</AccessKeyId>
+      <SecretAccessKey>assumed-secret</SecretAccessKey>
+      <SessionToken>assumed-token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer stsServer.Close()
+
+	config := testConfig(ecrServer.URL)
+	config.RoleARN = "arn:aws:iam::210987654321:role/pusher"
+	config.ExternalID = "external"
+	config.STSEndpoint = stsServer.URL
+	p, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.GetCredentials(otherRegistry); err != nil {
+		t.Fatal(err)
+	}
+	if len(*requests) != 1 || (*requests)[0].accessKey != "ASSUMEDKEY" {
+		t.Error("Expected ECR to be called with the assumed role:", *requests)
+	}
+}
@@ -0,0 +1,117 @@
+package aws
+
+import (
+	"cheops/docker"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/sts"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EnsureRepository creates the ECR repository of the image if it doesn't
+// exist yet, when the provider is configured to
+func (a *AWSDockerCredentialsProvider) EnsureRepository(image string) error {
+	if !a.config.CreateRepositories {
+		return nil
+	}
+
+	registry := docker.RegistryHost(image)
+	name := docker.RepositoryName(image)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	key := registry + "/" + name
+	if a.repositories[key] {
+		return nil
+	}
+
+	_, err := a.ecr.DescribeRepositories(&ecr.DescribeRepositoriesInput{
+		RegistryId:      aws.String(registryID(registry)),
+		RepositoryNames: []*string{aws.String(name)},
+	})
+	if isErrorCode(err, ecr.ErrCodeRepositoryNotFoundException) {
+		err = a.createRepository(registry, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	a.repositories[key] = true
+	return nil
+}
+
+// callerAccount returns the account of the credentials, which repositories
+// are created in. Must be called with the mutex held.
+func (a *AWSDockerCredentialsProvider) callerAccount() (string, error) {
+	if a.accountID != "" {
+		return a.accountID, nil
+	}
+
+	out, err := a.sts.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	a.accountID = aws.StringValue(out.Account)
+	return a.accountID, nil
+}
+
+func (a *AWSDockerCredentialsProvider) createRepository(registry, name string) error {
+	// CreateRepository has no registry ID, repositories of other accounts
+	// would end up in the account of the credentials
+	account, err := a.callerAccount()
+	if err != nil {
+		return err
+	}
+	if account != registryID(registry) {
+		return errors.New("Can't create repository " + name + " in " + registry +
+			" from account " + account + ", assume a role of the registry account")
+	}
+
+	log.WithFields(log.Fields{
+		"repository": name,
+	}).Info("Creating ECR repository")
+
+	input := &ecr.CreateRepositoryInput{
+		RepositoryName: aws.String(name),
+		ImageScanningConfiguration: &ecr.ImageScanningConfiguration{
+			ScanOnPush: aws.Bool(a.config.ScanOnPush),
+		},
+	}
+	if a.config.ImageTagMutability != "" {
+		input.ImageTagMutability = aws.String(a.config.ImageTagMutability)
+	}
+
+	out, err := a.ecr.CreateRepository(input)
+	if isErrorCode(err, ecr.ErrCodeRepositoryAlreadyExistsException) {
+		// Created concurrently, e.g. by another cheops instance
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if a.config.LifecyclePolicy == "" {
+		return nil
+	}
+
+	_, err = a.ecr.PutLifecyclePolicy(&ecr.PutLifecyclePolicyInput{
+		RegistryId:          out.Repository.RegistryId,
+		RepositoryName:      aws.String(name),
+		LifecyclePolicyText: aws.String(a.config.LifecyclePolicy),
+	})
+	if err != nil {
+		return errors.New("Can't set lifecycle policy of " + name + ": " + err.Error())
+	}
+
+	return nil
+}
+
+func isErrorCode(err error, code string) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == code
+}
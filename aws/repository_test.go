@@ -0,0 +1,165 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeRepositories is an ECR stand-in for the repository operations
+type fakeRepositories struct {
+	existing   map[string]bool
+	operations []string
+	created    map[string]interface{}
+	policy     map[string]interface{}
+}
+
+func (f *fakeRepositories) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.Header.Get("X-Amz-Target")
+	operation := target[strings.Index(target, ".")+1:]
+	f.operations = append(f.operations, operation)
+
+	body, _ := ioutil.ReadAll(r.Body)
+	input := map[string]interface{}{}
+	json.Unmarshal(body, &input)
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	switch operation {
+	case "DescribeRepositories":
+		name := input["repositoryNames"].([]interface{})[0].(string)
+		if !f.existing[name] {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"RepositoryNotFoundException","message":"The repository does not exist"}`))
+			return
+		}
+		w.Write([]byte(`{"repositories":[{"repositoryName":"` + name + `"}]}`))
+
+	case "CreateRepository":
+		f.created = input
+		w.Write([]byte(`{"repository":{"registryId":"123456789012","repositoryName":"` + input["repositoryName"].(string) + `"}}`))
+
+	case "PutLifecyclePolicy":
+		f.policy = input
+		w.Write([]byte(`{}`))
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// newFakeSTS serves GetCallerIdentity for credentials of the account
+func newFakeSTS(t *testing.T, account string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") != "GetCallerIdentity" {
+			t.Error("Unexpected STS request:", r.Form)
+		}
+
+		fmt.Fprintf(w, `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>arn:aws:iam::%s:user/cheops</Arn>
+    <UserId>AIDAEXAMPLE</UserId>
+    <Account>%s</Account>
+  </GetCallerIdentityResult>
+</GetCallerIdentityResponse>`, account, account)
+	}))
+}
+
+const lifecyclePolicy = `{"rules":[{"rulePriority":1,"description":"Keep the last 10 images",` +
+	`"selection":{"tagStatus":"any","countType":"imageCountMoreThan","countNumber":10},"action":{"type":"expire"}}]}`
+
+func TestEnsureRepository(t *testing.T) {
+	fake := &fakeRepositories{existing: map[string]bool{"existing": true}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	stsServer := newFakeSTS(t, "123456789012")
+	defer stsServer.Close()
+
+	config := testConfig(server.URL)
+	config.STSEndpoint = stsServer.URL
+	config.CreateRepositories = true
+	config.ImageTagMutability = "IMMUTABLE"
+	config.ScanOnPush = true
+	config.LifecyclePolicy = lifecyclePolicy
+	p, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.EnsureRepository(registry + "/existing:v1"); err != nil {
+		t.Fatal(err)
+	}
+	if fake.created != nil {
+		t.Error("Existing repository was created again")
+	}
+
+	if err := p.EnsureRepository(registry + "/team/app:v1"); err != nil {
+		t.Fatal(err)
+	}
+	if fake.created["repositoryName"] != "team/app" || fake.created["imageTagMutability"] != "IMMUTABLE" ||
+		fake.created["imageScanningConfiguration"].(map[string]interface{})["scanOnPush"] != true {
+		t.Error("Wrong repository:", fake.created)
+	}
+	if fake.policy["lifecyclePolicyText"] != lifecyclePolicy || fake.policy["registryId"] != "123456789012" {
+		t.Error("Wrong lifecycle policy:", fake.policy)
+	}
+
+	// Known repositories aren't described again
+	operations := len(fake.operations)
+	p.EnsureRepository(registry + "/team/app:v2")
+	if len(fake.operations) != operations {
+		t.Error("Unexpected requests:", fake.operations[operations:])
+	}
+}
+
+func TestEnsureRepositoryOtherAccount(t *testing.T) {
+	fake := &fakeRepositories{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	stsServer := newFakeSTS(t, "123456789012")
+	defer stsServer.Close()
+
+	config := testConfig(server.URL)
+	config.STSEndpoint = stsServer.URL
+	config.CreateRepositories = true
+	p, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.EnsureRepository(otherRegistry + "/app:v1")
+	if err == nil || !strings.Contains(err.Error(), "from account 123456789012") {
+		t.Error("Expected an error for another account, got", err)
+	}
+	if fake.created != nil {
+		t.Error("Repository created in the wrong account:", fake.created)
+	}
+}
+
+func TestEnsureRepositoryDisabled(t *testing.T) {
+	fake := &fakeRepositories{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	p, err := New(testConfig(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.EnsureRepository(registry + "/app"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.operations) != 0 {
+		t.Error("Unexpected requests:", fake.operations)
+	}
+
+	config := testConfig(server.URL)
+	config.ImageTagMutability = "SOMETIMES"
+	if _, err := New(config); err == nil {
+		t.Error("Expected an error for an invalid tag mutability")
+	}
+}
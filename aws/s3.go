@@ -0,0 +1,61 @@
+package aws
+
+import (
+	"cheops/types"
+	"context"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// S3StorageProvider uploads files to S3 or an S3 compatible store
+type S3StorageProvider struct {
+	uploader *s3manager.Uploader
+}
+
+func NewS3(config *Config) (*S3StorageProvider, error) {
+	log.WithFields(log.Fields{
+		"provider": "s3",
+		"endpoint": config.S3Endpoint,
+	}).Debug("Initializing storage provider")
+
+	s, err := NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+
+	s3Config := aws.NewConfig().WithS3ForcePathStyle(config.S3PathStyle)
+	if config.S3Endpoint != "" {
+		s3Config = s3Config.WithEndpoint(config.S3Endpoint)
+	}
+
+	return &S3StorageProvider{
+		uploader: s3manager.NewUploaderWithClient(s3.New(s, s3Config)),
+	}, nil
+}
+
+// Upload uploads a file, in multiple parts if it's large, and returns its
+// URL
+func (p *S3StorageProvider) Upload(ctx context.Context, file *types.UploadFile, body io.Reader) (string, error) {
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(file.Bucket),
+		Key:    aws.String(file.Key),
+		Body:   body,
+	}
+	if file.ContentType != "" {
+		input.ContentType = aws.String(file.ContentType)
+	}
+	if file.Public {
+		input.ACL = aws.String(s3.ObjectCannedACLPublicRead)
+	}
+
+	out, err := p.uploader.UploadWithContext(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return out.Location, nil
+}
@@ -0,0 +1,68 @@
+package aws
+
+import (
+	"cheops/types"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type s3Request struct {
+	method      string
+	path        string
+	contentType string
+	acl         string
+	body        string
+}
+
+func TestS3Upload(t *testing.T) {
+	requests := []s3Request{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, s3Request{
+			r.Method, r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("X-Amz-Acl"), string(body),
+		})
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+	}))
+	defer server.Close()
+
+	p, err := NewS3(&Config{
+		Region:          "us-east-1",
+		AccessKeyID:     "minio",
+		SecretAccessKey: "minio-secret",
+		S3Endpoint:      server.URL,
+		S3PathStyle:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := p.Upload(context.Background(), &types.UploadFile{
+		Bucket:      "builds",
+		Key:         "octo/app/main/abc/dist/app.tar.gz",
+		ContentType: "application/gzip",
+		Public:      true,
+	}, strings.NewReader("archive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if location != server.URL+"/builds/octo/app/main/abc/dist/app.tar.gz" {
+		t.Error("Wrong location:", location)
+	}
+	expected := s3Request{http.MethodPut, "/builds/octo/app/main/abc/dist/app.tar.gz", "application/gzip", "public-read", "archive"}
+	if len(requests) != 1 || requests[0] != expected {
+		t.Error("Wrong requests:", requests)
+	}
+
+	_, err = p.Upload(context.Background(), &types.UploadFile{Bucket: "builds", Key: "private"}, strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests[1].acl != "" {
+		t.Error("Expected no ACL for private files, got", requests[1].acl)
+	}
+}
@@ -0,0 +1,240 @@
+package azure
+
+import (
+	"cheops/docker"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultAuthority is the Azure AD endpoint of the public cloud
+const DefaultAuthority = "https://login.microsoftonline.com"
+
+const (
+	scope = "https://management.azure.com/.default"
+	// refreshTokenUsername is the user ACR expects with a refresh token
+	refreshTokenUsername = "00000000-0000-0000-0000-000000000000"
+	// expiryMargin is how long before its expiry a token is renewed
+	expiryMargin = 5 * time.Minute
+	// defaultLifetime is assumed for refresh tokens without an expiry
+	defaultLifetime = time.Hour
+)
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+type refreshToken struct {
+	token   string
+	expires time.Time
+}
+
+// AzureDockerCredentialsProvider supplies credentials for Azure Container
+// Registry, either the admin user of the registry or refresh tokens obtained
+// for a service principal
+type AzureDockerCredentialsProvider struct {
+	// registry is the host of the registry the admin user belongs to
+	registry string
+	username string
+	password string
+
+	tenantID         string
+	clientID         string
+	clientSecret     string
+	authority        string
+	registryEndpoint string
+	client           *http.Client
+
+	mutex  sync.Mutex
+	tokens map[string]*refreshToken
+}
+
+// NewAdmin creates a provider using the admin user of the registry, which
+// has no credentials for the other registries
+func NewAdmin(registry, username, password string) (*AzureDockerCredentialsProvider, error) {
+	log.WithFields(log.Fields{
+		"provider": "azure",
+		"registry": registry,
+	}).Debug("Initializing Docker credentials provider")
+
+	if registry == "" {
+		return nil, errors.New("Must specify the registry of the admin user")
+	}
+	if username == "" || password == "" {
+		return nil, errors.New("Must specify the registry admin username and password")
+	}
+
+	return &AzureDockerCredentialsProvider{
+		registry: docker.ServerHost(registry),
+		username: username,
+		password: password,
+	}, nil
+}
+
+// NewServicePrincipal creates a provider exchanging the client credentials of
+// a service principal for ACR refresh tokens. authority defaults to the
+// public cloud, registryEndpoint overrides the https://<registry> URL the
+// exchange is sent to.
+func NewServicePrincipal(tenantID, clientID, clientSecret, authority, registryEndpoint string) (*AzureDockerCredentialsProvider, error) {
+	log.WithFields(log.Fields{
+		"provider": "azure",
+		"client":   clientID,
+	}).Debug("Initializing Docker credentials provider")
+
+	if tenantID == "" || clientID == "" || clientSecret == "" {
+		return nil, errors.New("Must specify the tenant, client ID and client secret")
+	}
+
+	if authority == "" {
+		authority = DefaultAuthority
+	}
+
+	return &AzureDockerCredentialsProvider{
+		tenantID:         tenantID,
+		clientID:         clientID,
+		clientSecret:     clientSecret,
+		authority:        strings.TrimSuffix(authority, "/"),
+		registryEndpoint: strings.TrimSuffix(registryEndpoint, "/"),
+		client:           &http.Client{Timeout: 10 * time.Second},
+		tokens:           map[string]*refreshToken{},
+	}, nil
+}
+
+func (p *AzureDockerCredentialsProvider) postForm(endpoint string, form url.Values) (*tokenResponse, error) {
+	res, err := p.client.PostForm(endpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	token := &tokenResponse{}
+	if err := json.NewDecoder(res.Body).Decode(token); err != nil {
+		return nil, errors.New(res.Status)
+	}
+	if res.StatusCode != http.StatusOK {
+		message := strings.TrimSpace(token.Error + " " + token.Description)
+		if message == "" {
+			message = res.Status
+		}
+		return nil, errors.New(message)
+	}
+
+	return token, nil
+}
+
+// fetchRefreshToken gets an Azure AD access token for the service principal
+// and exchanges it for a refresh token of the registry
+func (p *AzureDockerCredentialsProvider) fetchRefreshToken(registry string) (*refreshToken, error) {
+	log.WithFields(log.Fields{
+		"client":   p.clientID,
+		"registry": registry,
+	}).Debug("Requesting ACR refresh token")
+
+	aad, err := p.postForm(p.authority+"/"+p.tenantID+"/oauth2/v2.0/token", url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {p.clientID},
+		"client_secret": {p.clientSecret},
+		"scope":         {scope},
+	})
+	if err != nil {
+		return nil, errors.New("Can't get Azure AD token: " + err.Error())
+	}
+
+	endpoint := p.registryEndpoint
+	if endpoint == "" {
+		endpoint = "https://" + registry
+	}
+
+	acr, err := p.postForm(endpoint+"/oauth2/exchange", url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {registry},
+		"tenant":       {p.tenantID},
+		"access_token": {aad.AccessToken},
+	})
+	if err != nil {
+		return nil, errors.New("Can't get ACR refresh token: " + err.Error())
+	}
+	if acr.RefreshToken == "" {
+		return nil, errors.New("Can't get ACR refresh token: empty response")
+	}
+
+	return &refreshToken{acr.RefreshToken, tokenExpiry(acr.RefreshToken)}, nil
+}
+
+// tokenExpiry reads the expiry of a JWT without verifying it
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) == 3 {
+		data, err := base64.RawURLEncoding.DecodeString(parts[1])
+		claims := struct {
+			Exp int64 `json:"exp"`
+		}{}
+		if err == nil && json.Unmarshal(data, &claims) == nil && claims.Exp > 0 {
+			return time.Unix(claims.Exp, 0)
+		}
+	}
+
+	return time.Now().Add(defaultLifetime)
+}
+
+// refreshToken returns the cached token of the registry, or a new one if
+// it's about to expire
+func (p *AzureDockerCredentialsProvider) refreshToken(registry string) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	token, ok := p.tokens[registry]
+	if ok && time.Now().Add(expiryMargin).Before(token.expires) {
+		return token.token, nil
+	}
+
+	token, err := p.fetchRefreshToken(registry)
+	if err != nil {
+		return "", err
+	}
+
+	p.tokens[registry] = token
+	return token.token, nil
+}
+
+func (p *AzureDockerCredentialsProvider) GetCredentials(registry string) (string, error) {
+	if !strings.Contains(registry, ".azurecr.") {
+		return "", errors.New("Not an Azure Container Registry: " + registry)
+	}
+	if p.registry != "" && registry != p.registry {
+		return "", errors.New("No credentials for registry: " + registry)
+	}
+
+	auth := types.AuthConfig{
+		Username:      p.username,
+		Password:      p.password,
+		ServerAddress: registry,
+	}
+
+	if p.clientID != "" {
+		token, err := p.refreshToken(registry)
+		if err != nil {
+			return "", err
+		}
+		auth.Username = refreshTokenUsername
+		auth.Password = token
+	}
+
+	bytes, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes), nil
+}
@@ -0,0 +1,125 @@
+package azure
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func fakeJWT(expires time.Time) string {
+	claims := fmt.Sprintf(`{"exp":%d}`, expires.Unix())
+	return "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2ln"
+}
+
+// newFakeAzure serves both the Azure AD token endpoint and the ACR exchange
+func newFakeAzure(t *testing.T, refreshToken string) (*httptest.Server, *int) {
+	exchanges := 0
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/tenant/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client","error_description":"Invalid client secret."}`)
+			return
+		}
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("client_id") != "client" {
+			t.Error("Wrong token request:", r.Form)
+		}
+		fmt.Fprint(w, `{"access_token":"aad-token","token_type":"Bearer"}`)
+	})
+
+	mux.HandleFunc("/oauth2/exchange", func(w http.ResponseWriter, r *http.Request) {
+		exchanges++
+		if r.FormValue("access_token") != "aad-token" || r.FormValue("service") != "myregistry.azurecr.io" ||
+			r.FormValue("tenant") != "tenant" {
+			t.Error("Wrong exchange request:", r.Form)
+		}
+		fmt.Fprintf(w, `{"refresh_token":"%s"}`, refreshToken)
+	})
+
+	return httptest.NewServer(mux), &exchanges
+}
+
+func TestAdmin(t *testing.T) {
+	if _, err := NewAdmin("", "myregistry", "password"); err == nil {
+		t.Error("Expected an error without a registry")
+	}
+
+	p, err := NewAdmin("https://myregistry.azurecr.io", "myregistry", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := p.GetCredentials("myregistry.azurecr.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds != `{"username":"myregistry","password":"password","serveraddress":"myregistry.azurecr.io"}` {
+		t.Error("Wrong credentials:", creds)
+	}
+
+	for _, registry := range []string{"docker.io", "other.azurecr.io"} {
+		if _, err := p.GetCredentials(registry); err == nil {
+			t.Error("Expected an error for", registry)
+		}
+	}
+}
+
+func TestServicePrincipal(t *testing.T) {
+	token := fakeJWT(time.Now().Add(3 * time.Hour))
+	server, exchanges := newFakeAzure(t, token)
+	defer server.Close()
+
+	p, err := NewServicePrincipal("tenant", "client", "secret", server.URL, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		creds, err := p.GetCredentials("myregistry.azurecr.io")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `{"username":"` + refreshTokenUsername + `","password":"` + token + `","serveraddress":"myregistry.azurecr.io"}`
+		if creds != expected {
+			t.Error("Wrong credentials:", creds)
+		}
+	}
+	if *exchanges != 1 {
+		t.Error("Expected the refresh token to be cached, got", *exchanges, "exchanges")
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	server, exchanges := newFakeAzure(t, fakeJWT(time.Now().Add(time.Minute)))
+	defer server.Close()
+
+	p, err := NewServicePrincipal("tenant", "client", "secret", server.URL, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.GetCredentials("myregistry.azurecr.io")
+	p.GetCredentials("myregistry.azurecr.io")
+	if *exchanges != 2 {
+		t.Error("Expected the refresh token to be renewed, got", *exchanges, "exchanges")
+	}
+}
+
+func TestInvalidSecret(t *testing.T) {
+	server, _ := newFakeAzure(t, "")
+	defer server.Close()
+
+	p, err := NewServicePrincipal("tenant", "client", "wrong", server.URL, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.GetCredentials("myregistry.azurecr.io")
+	if err == nil || err.Error() != "Can't get Azure AD token: invalid_client Invalid client secret." {
+		t.Error("Wrong error:", err)
+	}
+}
@@ -0,0 +1,325 @@
+package buildlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNotFound is returned when a build has no log
+	ErrNotFound = errors.New("Build log not found")
+	// ErrClosed is returned when subscribing to the log of a finished build
+	ErrClosed = errors.New("Build log closed")
+)
+
+// subscriberBuffer is the amount of lines a live tail can lag behind before
+// being dropped
+const subscriberBuffer = 1024
+
+// Manager keeps the logs of every build in a directory. Logs of running
+// builds are plain text files, they are gzipped once the build finishes.
+type Manager struct {
+	dir    string
+	mutex  sync.Mutex
+	active map[string]*Log
+}
+
+// Log is the output of a running build
+type Log struct {
+	id          string
+	manager     *Manager
+	mutex       sync.Mutex
+	file        *os.File
+	steps       []*stepWriter
+	subscribers map[*Subscription]bool
+	closed      bool
+}
+
+// Subscription is a live tail of a log
+type Subscription struct {
+	// Lines receives every new line. It's closed when the build finishes, or
+	// when the subscriber falls too far behind and is dropped.
+	Lines   <-chan string
+	lines   chan string
+	log     *Log
+	dropped bool
+}
+
+type stepWriter struct {
+	log  *Log
+	step string
+	buf  []byte
+}
+
+func NewManager(dir string) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &Manager{
+		dir:    dir,
+		active: make(map[string]*Log),
+	}, nil
+}
+
+func (m *Manager) path(id string) string {
+	return filepath.Join(m.dir, id+".log")
+}
+
+func (m *Manager) compressedPath(id string) string {
+	return filepath.Join(m.dir, id+".log.gz")
+}
+
+// Create starts the log of a build
+func (m *Manager) Create(id string) (*Log, error) {
+	file, err := os.OpenFile(m.path(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		id:          id,
+		manager:     m,
+		file:        file,
+		subscribers: make(map[*Subscription]bool),
+	}
+
+	m.mutex.Lock()
+	m.active[id] = l
+	m.mutex.Unlock()
+
+	return l, nil
+}
+
+// Active returns the log of a running build, or nil if the build isn't
+// running
+func (m *Manager) Active(id string) *Log {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.active[id]
+}
+
+type gzipReadCloser struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// Open returns the full log of a build, running or finished
+func (m *Manager) Open(id string) (io.ReadCloser, error) {
+	if l := m.Active(id); l != nil {
+		l.mutex.Lock()
+		if !l.closed {
+			data, err := ioutil.ReadFile(m.path(id))
+			l.mutex.Unlock()
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(bytes.NewReader(data)), nil
+		}
+		l.mutex.Unlock()
+	}
+
+	file, err := os.Open(m.compressedPath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &gzipReadCloser{reader, file}, nil
+}
+
+// Remove deletes the log of a finished build
+func (m *Manager) Remove(id string) error {
+	err := os.Remove(m.compressedPath(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Step returns a writer that tags every line written to it with the step
+// name. Output written to a nil Log is discarded.
+func (l *Log) Step(name string) io.Writer {
+	if l == nil {
+		return ioutil.Discard
+	}
+
+	w := &stepWriter{log: l, step: name}
+
+	l.mutex.Lock()
+	l.steps = append(l.steps, w)
+	l.mutex.Unlock()
+
+	return w
+}
+
+// writeLine must be called with the mutex held
+func (l *Log) writeLine(step string, line []byte) {
+	if l.closed {
+		return
+	}
+
+	formatted := time.Now().Format(time.RFC3339) + " [" + step + "] " + string(line)
+	if _, err := io.WriteString(l.file, formatted+"\n"); err != nil {
+		log.WithFields(log.Fields{
+			"build": l.id,
+			"error": err,
+		}).Warn("Can't write build log")
+	}
+
+	for subscriber := range l.subscribers {
+		select {
+		case subscriber.lines <- formatted:
+		default:
+			// Too slow, drop it rather than blocking the build
+			subscriber.dropped = true
+			delete(l.subscribers, subscriber)
+			close(subscriber.lines)
+		}
+	}
+}
+
+func (w *stepWriter) Write(data []byte) (int, error) {
+	w.log.mutex.Lock()
+	defer w.log.mutex.Unlock()
+
+	w.buf = append(w.buf, data...)
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		if i > 0 {
+			w.log.writeLine(w.step, w.buf[:i])
+		}
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(data), nil
+}
+
+// Subscribe returns the log written so far and a subscription receiving
+// every new line
+func (l *Log) Subscribe() ([]byte, *Subscription, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return nil, nil, ErrClosed
+	}
+
+	history, err := ioutil.ReadFile(l.manager.path(l.id))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lines := make(chan string, subscriberBuffer)
+	subscription := &Subscription{Lines: lines, lines: lines, log: l}
+	l.subscribers[subscription] = true
+
+	return history, subscription, nil
+}
+
+// Dropped reports whether Lines was closed because the subscriber fell
+// behind, rather than because the build finished
+func (s *Subscription) Dropped() bool {
+	s.log.mutex.Lock()
+	defer s.log.mutex.Unlock()
+	return s.dropped
+}
+
+// Cancel stops the subscription
+func (s *Subscription) Cancel() {
+	s.log.mutex.Lock()
+	defer s.log.mutex.Unlock()
+	if s.log.subscribers[s] {
+		delete(s.log.subscribers, s)
+		close(s.lines)
+	}
+}
+
+func (l *Log) compress() error {
+	src, err := os.Open(l.manager.path(l.id))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpPath := l.manager.compressedPath(l.id) + ".tmp"
+	dst, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, l.manager.compressedPath(l.id)); err != nil {
+		return err
+	}
+
+	return os.Remove(l.manager.path(l.id))
+}
+
+// Close flushes the log, ends the live tails and compresses the log file
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	for _, step := range l.steps {
+		if len(step.buf) > 0 {
+			l.writeLine(step.step, step.buf)
+			step.buf = nil
+		}
+	}
+
+	l.closed = true
+	for subscriber := range l.subscribers {
+		close(subscriber.lines)
+	}
+	l.subscribers = nil
+	err := l.file.Close()
+	if err == nil {
+		err = l.compress()
+	}
+	l.mutex.Unlock()
+
+	l.manager.mutex.Lock()
+	delete(l.manager.active, l.id)
+	l.manager.mutex.Unlock()
+
+	return err
+}
@@ -0,0 +1,161 @@
+package buildlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newTestManager(t *testing.T) (*Manager, func()) {
+	dir, err := ioutil.TempDir("", "cheops-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewManager(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return m, func() { os.RemoveAll(dir) }
+}
+
+func readLog(t *testing.T, m *Manager, id string) string {
+	reader, err := m.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestLog(t *testing.T) {
+	m, cleanup := newTestManager(t)
+	defer cleanup()
+
+	l, err := m.Create("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clone := l.Step("clone")
+	build := l.Step("build")
+	fmt.Fprint(clone, "Counting objects\rDone\n")
+	fmt.Fprint(build, "Step 1/2")
+	fmt.Fprint(build, " : FROM alpine\nStep 2/2")
+
+	running := readLog(t, m, "1")
+	if !strings.Contains(running, "[clone] Counting objects\n") ||
+		!strings.Contains(running, "[clone] Done\n") ||
+		!strings.Contains(running, "[build] Step 1/2 : FROM alpine\n") {
+		t.Error("Wrong log:", running)
+	}
+	if strings.Contains(running, "Step 2/2") {
+		t.Error("Partial lines shouldn't be written:", running)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Active("1") != nil {
+		t.Error("Log still active after closing")
+	}
+
+	finished := readLog(t, m, "1")
+	if !strings.HasPrefix(finished, running) || !strings.Contains(finished, "[build] Step 2/2\n") {
+		t.Error("Wrong compressed log:", finished)
+	}
+
+	if err := m.Remove("1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Open("1"); err != ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	m, cleanup := newTestManager(t)
+	defer cleanup()
+
+	l, err := m.Create("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := l.Step("exec")
+	fmt.Fprintln(step, "before")
+
+	history, subscription, err := l.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscription.Cancel()
+
+	if !strings.HasSuffix(string(history), "[exec] before\n") {
+		t.Error("Wrong history:", string(history))
+	}
+
+	fmt.Fprintln(step, "after")
+	l.Close()
+
+	received := []string{}
+	for line := range subscription.Lines {
+		received = append(received, line)
+	}
+	if len(received) != 1 || !strings.HasSuffix(received[0], "[exec] after") {
+		t.Error("Wrong lines:", received)
+	}
+	if subscription.Dropped() {
+		t.Error("Subscription dropped")
+	}
+
+	if _, _, err := l.Subscribe(); err != ErrClosed {
+		t.Error("Expected ErrClosed, got", err)
+	}
+}
+
+func TestSubscribeDropped(t *testing.T) {
+	m, cleanup := newTestManager(t)
+	defer cleanup()
+
+	l, err := m.Create("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	_, subscription, err := l.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscription.Cancel()
+
+	step := l.Step("exec")
+	for i := 0; i <= subscriberBuffer; i++ {
+		fmt.Fprintln(step, "line", i)
+	}
+
+	received := 0
+	for range subscription.Lines {
+		received++
+	}
+	if received != subscriberBuffer || !subscription.Dropped() {
+		t.Error("Expected a dropped subscription, got", received, "lines")
+	}
+}
+
+func TestNilLog(t *testing.T) {
+	var l *Log
+	fmt.Fprintln(l.Step("clone"), "discarded")
+	if err := l.Close(); err != nil {
+		t.Error(err)
+	}
+}
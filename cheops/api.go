@@ -0,0 +1,497 @@
+package cheops
+
+import (
+	"cheops/artifacts"
+	"cheops/buildlog"
+	"cheops/store"
+	"cheops/types"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type buildRequest struct {
+	Repo   string            `json:"repo"`
+	Branch string            `json:"branch"`
+	Commit string            `json:"commit"`
+	Params map[string]string `json:"params"`
+}
+
+type buildResponse struct {
+	ID string `json:"id"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warn("Can't write API response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, &errorResponse{Error: message})
+}
+
+func (c *cheopsImpl) authorized(r *http.Request) bool {
+	token := c.Config().General.APIToken
+	if token == "" {
+		return false
+	}
+
+	// The API token is only accepted in the header, so that it doesn't end
+	// up in access logs and browser history. Clients that can't set headers
+	// use build tokens instead.
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	requestToken := auth[len("Bearer "):]
+
+	return subtle.ConstantTimeCompare([]byte(requestToken), []byte(token)) == 1
+}
+
+// apiHandler wraps an API handler with authentication
+func (c *cheopsImpl) apiHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !c.authorized(r) && !c.buildTokenAuthorized(r) {
+			log.WithFields(log.Fields{
+				"path":   r.URL.Path,
+				"remote": r.RemoteAddr,
+			}).Warn("Unauthorized API request")
+			writeError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func (c *cheopsImpl) registerAPI() {
+	if c.Config().General.APIToken == "" {
+		log.Warn("No API token configured, the REST API will reject every request")
+	}
+
+	http.HandleFunc("/api/builds", c.apiHandler(c.handleBuilds))
+	http.HandleFunc("/api/builds/", c.apiHandler(c.handleBuild))
+	http.HandleFunc("/api/repos", c.apiHandler(c.handleListRepos))
+	http.HandleFunc("/api/deliveries", c.apiHandler(c.handleListDeliveries))
+	http.HandleFunc("/api/deliveries/", c.apiHandler(c.handleDelivery))
+}
+
+// writeStartError reports why a build couldn't be started
+func writeStartError(w http.ResponseWriter, err error) {
+	switch err {
+	case store.ErrNotFound, store.ErrDeliveryNotFound:
+		writeError(w, http.StatusNotFound, err.Error())
+	default:
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	}
+}
+
+type repoResponse struct {
+	Provider string   `json:"provider"`
+	URL      string   `json:"url"`
+	Branches []string `json:"branches"`
+}
+
+// handleListRepos lists the configured repositories, without their secrets
+func (c *cheopsImpl) handleListRepos(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	repos := []*repoResponse{}
+	for _, repo := range c.Config().Repos {
+		branches := []string{}
+		if repo.Branch != "" {
+			branches = append(branches, repo.Branch)
+		}
+		branches = append(branches, repo.Branches...)
+
+		repos = append(repos, &repoResponse{
+			Provider: repo.Provider,
+			URL:      repo.URL,
+			Branches: branches,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, repos)
+}
+
+func (c *cheopsImpl) handleBuilds(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		c.handleListBuilds(w, r)
+
+	case http.MethodPost:
+		c.handleTriggerBuild(w, r)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (c *cheopsImpl) handleTriggerBuild(w http.ResponseWriter, r *http.Request) {
+	var req buildRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	if req.Repo == "" || req.Branch == "" {
+		writeError(w, http.StatusBadRequest, "repo and branch are required")
+		return
+	}
+
+	repo, err := findRepo(c.Config().Repos, req.Repo, req.Branch)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	provider, ok := c.gitProviders[repo.Provider]
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Unknown provider: "+repo.Provider)
+		return
+	}
+
+	commitID := req.Commit
+	if commitID == "" {
+		commitID, err = provider.ResolveBranch(repo.URL, req.Branch)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"repository": repo.URL,
+				"branch":     req.Branch,
+				"error":      err,
+			}).Warn("Can't resolve branch head")
+			writeError(w, http.StatusBadGateway, "Can't resolve branch head: "+err.Error())
+			return
+		}
+	}
+
+	commit := &types.CommitInfo{
+		ID:      commitID,
+		Branch:  req.Branch,
+		RepoURL: repo.URL,
+	}
+
+	id := c.startBuild(repo, newBuildRecord(newBuildID(), commit, req.Params))
+	writeJSON(w, http.StatusAccepted, &buildResponse{ID: id})
+}
+
+func (c *cheopsImpl) handleListBuilds(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := &types.BuildFilter{
+		RepoURL: query.Get("repo"),
+		Branch:  query.Get("branch"),
+		Status:  query.Get("status"),
+		Limit:   50,
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid limit: "+limit)
+			return
+		}
+		filter.Limit = n
+	}
+
+	records, err := c.store.ListBuilds(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, records)
+}
+
+// handleBuild serves everything under /api/builds/<id>
+func (c *cheopsImpl) handleBuild(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/builds/"), "/", 2)
+	id := parts[0]
+	resource := ""
+	if len(parts) > 1 {
+		resource = parts[1]
+	}
+
+	if id == "" {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	method := http.MethodGet
+	if resource == "cancel" || resource == "rerun" || resource == "token" {
+		method = http.MethodPost
+	}
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	switch resource {
+	case "":
+		c.handleGetBuild(w, id)
+
+	case "log":
+		c.handleGetLog(w, id)
+
+	case "log/stream":
+		c.handleStreamLog(w, r, id)
+
+	case "artifacts":
+		c.handleListArtifacts(w, id)
+
+	case "token":
+		c.handleBuildToken(w, id)
+
+	case "cancel":
+		c.handleCancelBuild(w, id)
+
+	case "rerun":
+		newID, err := c.rerunBuild(id)
+		if err != nil {
+			writeStartError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, &buildResponse{ID: newID})
+
+	default:
+		if strings.HasPrefix(resource, "artifacts/") {
+			c.handleGetArtifact(w, r, id, strings.TrimPrefix(resource, "artifacts/"))
+			return
+		}
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (c *cheopsImpl) handleListArtifacts(w http.ResponseWriter, id string) {
+	record, err := c.store.GetBuild(id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	artifacts := record.Artifacts
+	if artifacts == nil {
+		artifacts = []*types.ArtifactRecord{}
+	}
+	writeJSON(w, http.StatusOK, artifacts)
+}
+
+// handleGetArtifact downloads an artifact of a build
+func (c *cheopsImpl) handleGetArtifact(w http.ResponseWriter, r *http.Request, id, name string) {
+	if c.artifacts == nil {
+		writeError(w, http.StatusNotFound, artifacts.ErrNotFound.Error())
+		return
+	}
+
+	file, err := c.artifacts.Open(id, name)
+	if err == artifacts.ErrNotFound {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(name)))
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
+
+func (c *cheopsImpl) handleCancelBuild(w http.ResponseWriter, id string) {
+	if !c.cancelBuild(id) {
+		writeError(w, http.StatusConflict, "Build not running")
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, &buildResponse{ID: id})
+}
+
+func (c *cheopsImpl) handleGetBuild(w http.ResponseWriter, id string) {
+	record, err := c.store.GetBuild(id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, record)
+}
+
+func (c *cheopsImpl) handleGetLog(w http.ResponseWriter, id string) {
+	reader, err := c.logs.Open(id)
+	if err == buildlog.ErrNotFound {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.Copy(w, reader)
+}
+
+func writeEvent(w http.ResponseWriter, event, data string) {
+	if event != "" {
+		fmt.Fprintf(w, "event: %s\n", event)
+	}
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+// handleStreamLog tails the log of a build as Server-Sent Events, one event
+// per line, followed by an "end" event when the build finishes. Streams too
+// slow to keep up are closed without it, so that the client reconnects.
+func (c *cheopsImpl) handleStreamLog(w http.ResponseWriter, r *http.Request, id string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	var history []byte
+	var subscription *buildlog.Subscription
+	var lines <-chan string
+
+	err := buildlog.ErrClosed
+	if buildLog := c.logs.Active(id); buildLog != nil {
+		history, subscription, err = buildLog.Subscribe()
+	}
+	if err == buildlog.ErrClosed {
+		var reader io.ReadCloser
+		reader, err = c.logs.Open(id)
+		if err == nil {
+			history, err = ioutil.ReadAll(reader)
+			reader.Close()
+		}
+	}
+	if err == buildlog.ErrNotFound {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if subscription != nil {
+		defer subscription.Cancel()
+		lines = subscription.Lines
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(history), "\n"), "\n") {
+		if line != "" {
+			writeEvent(w, "", line)
+		}
+	}
+	flusher.Flush()
+
+	for lines != nil {
+		select {
+		case line, ok := <-lines:
+			if !ok && subscription.Dropped() {
+				return
+			}
+			if !ok {
+				lines = nil
+				continue
+			}
+			writeEvent(w, "", line)
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	writeEvent(w, "end", id)
+	flusher.Flush()
+}
+
+func (c *cheopsImpl) handleListDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	deliveries, err := c.store.ListDeliveries(50)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// handleDelivery serves everything under /api/deliveries/<id>
+func (c *cheopsImpl) handleDelivery(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/deliveries/"), "/", 2)
+	id := parts[0]
+	resource := ""
+	if len(parts) > 1 {
+		resource = parts[1]
+	}
+
+	switch {
+	case id == "":
+		writeError(w, http.StatusNotFound, "Not found")
+
+	case resource == "" && r.Method == http.MethodGet:
+		delivery, err := c.store.GetDelivery(id)
+		if err == store.ErrDeliveryNotFound {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, delivery)
+
+	case resource == "replay" && r.Method == http.MethodPost:
+		buildID, err := c.replayDelivery(id)
+		if err != nil {
+			writeStartError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, &buildResponse{ID: buildID})
+
+	case resource == "" || resource == "replay":
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
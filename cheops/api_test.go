@@ -0,0 +1,386 @@
+package cheops
+
+import (
+	"bytes"
+	"cheops/artifacts"
+	"cheops/buildlog"
+	"cheops/store"
+	"cheops/types"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeGitProvider struct {
+	heads map[string]string
+}
+
+func (p *fakeGitProvider) Clone(commit *types.CommitInfo, targetDir string, progress io.Writer) error {
+	return errors.New("Not implemented")
+}
+
+func (p *fakeGitProvider) ResolveBranch(repoURL, branch string) (string, error) {
+	head, ok := p.heads[branch]
+	if !ok {
+		return "", errors.New("Branch not found: " + branch)
+	}
+	return head, nil
+}
+
+func (p *fakeGitProvider) RegisterRepo(repo *types.Repository) error {
+	return nil
+}
+
+// fakeWebhook takes the commit to build as JSON
+func fakeWebhook(body io.ReadCloser, headers map[string][]string) (*types.CommitInfo, error) {
+	commit := &types.CommitInfo{}
+	if err := json.NewDecoder(body).Decode(commit); err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
+func newTestCheops(t *testing.T) (*cheopsImpl, func()) {
+	dir, err := ioutil.TempDir("", "cheops")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := store.New(filepath.Join(dir, "cheops.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs, err := buildlog.NewManager(filepath.Join(dir, "logs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	artifactStore, err := artifacts.NewStore(filepath.Join(dir, "artifacts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &cheopsImpl{
+		config: &types.CheopsConfig{
+			General: types.GeneralConfig{APIToken: "secret"},
+			Repos: []*types.Repository{
+				{Provider: "fake", URL: "https://example.com/repo.git", Branch: "main"},
+			},
+		},
+		gitProviders: map[string]types.GitProvider{
+			"fake": &fakeGitProvider{heads: map[string]string{"main": "abcdef"}},
+		},
+		dockerCredsProviders: map[string]types.DockerCredsProvider{},
+		store:                s,
+		logs:                 logs,
+		artifacts:            artifactStore,
+		running:              map[string]context.CancelFunc{},
+		webhooks:             map[string]types.WebhookFunc{"/fake": fakeWebhook},
+	}
+
+	return c, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func postBuild(c *cheopsImpl, token string, req *buildRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest(http.MethodPost, "/api/builds", bytes.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	c.apiHandler(c.handleBuilds)(w, r)
+	return w
+}
+
+func TestTriggerBuild(t *testing.T) {
+	c, cleanup := newTestCheops(t)
+	defer cleanup()
+
+	w := postBuild(c, "", &buildRequest{Repo: "https://example.com/repo.git", Branch: "main"})
+	if w.Code != http.StatusUnauthorized {
+		t.Error("Expected 401 without token, got", w.Code)
+	}
+
+	w = postBuild(c, "wrong", &buildRequest{Repo: "https://example.com/repo.git", Branch: "main"})
+	if w.Code != http.StatusUnauthorized {
+		t.Error("Expected 401 with a wrong token, got", w.Code)
+	}
+
+	w = postBuild(c, "secret", &buildRequest{Repo: "https://example.com/repo.git", Branch: "dev"})
+	if w.Code != http.StatusNotFound {
+		t.Error("Expected 404 for an unknown branch, got", w.Code)
+	}
+
+	w = postBuild(c, "secret", &buildRequest{Repo: "https://example.com/repo.git", Branch: "main"})
+	if w.Code != http.StatusAccepted {
+		t.Fatal("Expected 202, got", w.Code, w.Body.String())
+	}
+
+	var res buildResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.ID == "" {
+		t.Error("Missing build ID:", w.Body.String())
+	}
+}
+
+func getAPI(c *cheopsImpl, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	c.apiHandler(c.handleBuild)(w, r)
+	return w
+}
+
+func TestGetBuild(t *testing.T) {
+	c, cleanup := newTestCheops(t)
+	defer cleanup()
+
+	record := newBuildRecord("1234", &types.CommitInfo{ID: "abc", Branch: "main"}, nil)
+	c.saveRecord(record)
+
+	w := getAPI(c, "/api/builds/1234")
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected 200, got", w.Code)
+	}
+
+	var res types.BuildRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Commit.ID != "abc" {
+		t.Error("Wrong build:", w.Body.String())
+	}
+
+	w = getAPI(c, "/api/builds/5678")
+	if w.Code != http.StatusNotFound {
+		t.Error("Expected 404, got", w.Code)
+	}
+}
+
+func TestBuildLog(t *testing.T) {
+	c, cleanup := newTestCheops(t)
+	defer cleanup()
+
+	buildLog := c.createLog("1234")
+	io.WriteString(buildLog.Step("clone"), "Cloning\n")
+
+	w := getAPI(c, "/api/builds/1234/log")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "[clone] Cloning") {
+		t.Error("Wrong running log:", w.Code, w.Body.String())
+	}
+
+	c.closeLog(buildLog, "1234")
+
+	w = getAPI(c, "/api/builds/1234/log")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "[clone] Cloning") {
+		t.Error("Wrong finished log:", w.Code, w.Body.String())
+	}
+
+	w = getAPI(c, "/api/builds/1234/log/stream")
+	if w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), "data: ") ||
+		!strings.HasSuffix(w.Body.String(), "event: end\ndata: 1234\n\n") {
+		t.Error("Wrong log stream:", w.Code, w.Body.String())
+	}
+
+	w = getAPI(c, "/api/builds/5678/log")
+	if w.Code != http.StatusNotFound {
+		t.Error("Expected 404, got", w.Code)
+	}
+}
+
+func TestBuildArtifacts(t *testing.T) {
+	c, cleanup := newTestCheops(t)
+	defer cleanup()
+
+	record := newBuildRecord("1234", &types.CommitInfo{ID: "abc", Branch: "main"}, nil)
+	c.saveRecord(record)
+	err := c.storeArtifacts(record, "build app", tarArchive(map[string]string{
+		"dist/app":               "binary",
+		"dist/reports/junit.xml": "<testsuites/>",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := getAPI(c, "/api/builds/1234/artifacts")
+	var res []*types.ArtifactRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 2 ||
+		res[0].Path != "dist/app" || res[0].Size != 6 || res[0].Step != "build app" {
+		t.Error("Wrong artifacts:", w.Code, w.Body.String())
+	}
+
+	w = getAPI(c, "/api/builds/1234/artifacts/dist/reports/junit.xml")
+	if w.Code != http.StatusOK || w.Body.String() != "<testsuites/>" ||
+		w.Header().Get("Content-Disposition") != `attachment; filename="junit.xml"` {
+		t.Error("Wrong artifact:", w.Code, w.Body.String())
+	}
+
+	for _, path := range []string{
+		"/api/builds/1234/artifacts/dist/missing",
+		"/api/builds/1234/artifacts/dist",
+		"/api/builds/1234/artifacts/../../cheops.db",
+		"/api/builds/5678/artifacts",
+	} {
+		if code := getAPI(c, path).Code; code != http.StatusNotFound {
+			t.Error("Expected 404 for", path, "got", code)
+		}
+	}
+}
+
+func getWithoutHeader(c *cheopsImpl, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	w := httptest.NewRecorder()
+	c.apiHandler(c.handleBuild)(w, r)
+	return w
+}
+
+func TestBuildToken(t *testing.T) {
+	c, cleanup := newTestCheops(t)
+	defer cleanup()
+
+	record := newBuildRecord("1234", &types.CommitInfo{ID: "abc", Branch: "main"}, nil)
+	c.saveRecord(record)
+	err := c.storeArtifacts(record, "build app", tarArchive(map[string]string{"dist/app": "binary"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := postAPI(c, c.handleBuild, "/api/builds/1234/token")
+	var res buildTokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK || res.Token == "" {
+		t.Fatal("Wrong build token:", w.Code, w.Body.String())
+	}
+	if res.ExpiresAt.Before(time.Now()) || res.ExpiresAt.After(time.Now().Add(buildTokenLifetime)) {
+		t.Error("Wrong expiry:", res.ExpiresAt)
+	}
+
+	w = getWithoutHeader(c, "/api/builds/1234/artifacts/dist/app?token="+res.Token)
+	if w.Code != http.StatusOK || w.Body.String() != "binary" {
+		t.Error("Wrong artifact:", w.Code, w.Body.String())
+	}
+
+	expired := c.buildToken("1234", time.Now().Add(-time.Second))
+	for _, path := range []string{
+		"/api/builds/1234?token=" + res.Token,
+		"/api/builds/1234/log?token=" + res.Token,
+		"/api/builds/5678/artifacts/dist/app?token=" + res.Token,
+		"/api/builds/1234/artifacts/dist/app?token=" + expired,
+		"/api/builds/1234/artifacts/dist/app?token=secret",
+		"/api/builds/1234/log/stream?token=secret",
+	} {
+		if code := getWithoutHeader(c, path).Code; code != http.StatusUnauthorized {
+			t.Error("Expected 401 for", path, "got", code)
+		}
+	}
+
+	if code := postAPI(c, c.handleBuild, "/api/builds/5678/token").Code; code != http.StatusNotFound {
+		t.Error("Expected 404, got", code)
+	}
+}
+
+func TestCancelBuild(t *testing.T) {
+	c, cleanup := newTestCheops(t)
+	defer cleanup()
+
+	ctx := c.trackBuild("1234")
+	defer c.untrackBuild("1234")
+
+	if code := postAPI(c, c.handleBuild, "/api/builds/1234/cancel").Code; code != http.StatusAccepted {
+		t.Error("Expected 202, got", code)
+	}
+	if ctx.Err() != context.Canceled {
+		t.Error("Build context not cancelled")
+	}
+
+	if code := postAPI(c, c.handleBuild, "/api/builds/5678/cancel").Code; code != http.StatusConflict {
+		t.Error("Expected 409, got", code)
+	}
+}
+
+func postAPI(c *cheopsImpl, handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	c.apiHandler(handler)(w, r)
+	return w
+}
+
+func TestRerunBuild(t *testing.T) {
+	c, cleanup := newTestCheops(t)
+	defer cleanup()
+
+	commit := &types.CommitInfo{ID: "abc", Branch: "main", RepoURL: "https://example.com/repo.git"}
+	c.saveRecord(newBuildRecord("1234", commit, map[string]string{"env": "prod"}))
+
+	w := postAPI(c, c.handleBuild, "/api/builds/1234/rerun")
+	if w.Code != http.StatusAccepted {
+		t.Fatal("Expected 202, got", w.Code, w.Body.String())
+	}
+
+	var res buildResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	record, err := c.store.GetBuild(res.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.RerunOf != "1234" || record.Commit.ID != "abc" || record.Params["env"] != "prod" {
+		t.Error("Wrong re-run:", record)
+	}
+
+	w = postAPI(c, c.handleBuild, "/api/builds/5678/rerun")
+	if w.Code != http.StatusNotFound {
+		t.Error("Expected 404, got", w.Code)
+	}
+}
+
+func TestReplayDelivery(t *testing.T) {
+	c, cleanup := newTestCheops(t)
+	defer cleanup()
+
+	c.saveDelivery(&types.Delivery{
+		ID:       "1",
+		Endpoint: "/fake",
+		Payload:  []byte(`{"id":"abc","branch":"main","repo_url":"https://example.com/repo.git"}`),
+		BuildID:  "1234",
+	})
+	c.saveDelivery(&types.Delivery{
+		ID:       "2",
+		Endpoint: "/fake",
+		Payload:  []byte(`{"id":"abc","branch":"dev","repo_url":"https://example.com/repo.git"}`),
+	})
+
+	w := postAPI(c, c.handleDelivery, "/api/deliveries/1/replay")
+	if w.Code != http.StatusAccepted {
+		t.Fatal("Expected 202, got", w.Code, w.Body.String())
+	}
+
+	var res buildResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	record, err := c.store.GetBuild(res.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.DeliveryID != "1" || record.RerunOf != "1234" || record.Commit.ID != "abc" {
+		t.Error("Wrong replay:", record)
+	}
+
+	w = postAPI(c, c.handleDelivery, "/api/deliveries/2/replay")
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected 422 for an unknown branch, got", w.Code)
+	}
+
+	w = postAPI(c, c.handleDelivery, "/api/deliveries/3/replay")
+	if w.Code != http.StatusNotFound {
+		t.Error("Expected 404, got", w.Code)
+	}
+}
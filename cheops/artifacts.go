@@ -0,0 +1,72 @@
+package cheops
+
+import (
+	"cheops/docker"
+	"cheops/types"
+	"context"
+	"errors"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// containerImage returns the local image of a built container, the one of
+// the first platform for multi-platform containers
+func containerImage(container *types.Container) string {
+	if len(container.Platforms) > 0 {
+		return docker.PlatformTag(container.Tag, container.Platforms[0])
+	}
+	return container.Tag
+}
+
+// exportArtifacts copies paths out of an image into the artifacts of the
+// build
+func (c *cheopsImpl) exportArtifacts(ctx context.Context, record *types.BuildRecord, step, image string, paths []string) error {
+	for _, path := range paths {
+		archive, err := c.engine.CopyFromImage(ctx, image, path)
+		if err != nil {
+			return err
+		}
+
+		err = c.storeArtifacts(record, step, archive)
+		archive.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeArtifacts extracts a tar archive into the artifacts of the build and
+// records its files
+func (c *cheopsImpl) storeArtifacts(record *types.BuildRecord, step string, archive io.Reader) error {
+	if c.artifacts == nil {
+		return errors.New("No artifact storage")
+	}
+
+	artifacts, err := c.artifacts.Extract(record.ID, archive)
+	for _, artifact := range artifacts {
+		artifact.Step = step
+		c.recordArtifact(record, artifact)
+	}
+	c.saveRecord(record)
+
+	log.WithFields(log.Fields{
+		"build":     record.ID,
+		"step":      step,
+		"artifacts": len(artifacts),
+	}).Debug("Stored artifacts")
+	return err
+}
+
+// recordArtifact adds an artifact to the build, replacing a previous one
+// with the same path
+func (c *cheopsImpl) recordArtifact(record *types.BuildRecord, artifact *types.ArtifactRecord) {
+	for i, previous := range record.Artifacts {
+		if previous.Path == artifact.Path {
+			record.Artifacts[i] = artifact
+			return
+		}
+	}
+	record.Artifacts = append(record.Artifacts, artifact)
+}
@@ -2,14 +2,32 @@ package cheops
 
 import (
 	"bytes"
+	"cheops/artifacts"
 	"cheops/aws"
+	"cheops/azure"
+	"cheops/buildlog"
 	"cheops/config"
 	"cheops/docker"
+	"cheops/dockerconfig"
+	"cheops/gcp"
+	"cheops/git"
 	"cheops/github"
+	"cheops/registry"
+	"cheops/store"
 	"cheops/types"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"html/template"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
@@ -19,6 +37,14 @@ type cheopsImpl struct {
 	config               *types.CheopsConfig
 	gitProviders         map[string]types.GitProvider
 	dockerCredsProviders map[string]types.DockerCredsProvider
+	storageProviders     map[string]types.StorageProvider
+	engine               types.Engine
+	store                types.BuildStore
+	logs                 *buildlog.Manager
+	artifacts            *artifacts.Store
+	webhooks             map[string]types.WebhookFunc
+	running              map[string]context.CancelFunc
+	runningMutex         sync.Mutex
 }
 
 func (c *cheopsImpl) Config() *types.CheopsConfig {
@@ -28,11 +54,113 @@ func (c *cheopsImpl) Config() *types.CheopsConfig {
 func (c *cheopsImpl) initDockerCredsProvider(providerConfig *types.DockerCredsProviderConfig) (types.DockerCredsProvider, error) {
 	switch providerConfig.Type {
 	case "aws":
-		provider, err := aws.New(
-			providerConfig.AwsRegion,
-			providerConfig.AwsAccessKeyID,
-			providerConfig.AwsSecretAccessKey,
-			providerConfig.AwsSessionToken,
+		provider, err := aws.New(&aws.Config{
+			Region:          providerConfig.AwsRegion,
+			AccessKeyID:     providerConfig.AwsAccessKeyID,
+			SecretAccessKey: providerConfig.AwsSecretAccessKey,
+			SessionToken:    providerConfig.AwsSessionToken,
+			Profile:         providerConfig.AwsProfile,
+			RoleARN:         providerConfig.AwsRoleARN,
+			ExternalID:      providerConfig.AwsExternalID,
+			RegistryIDs:     providerConfig.AwsRegistryIDs,
+			Endpoint:        providerConfig.AwsEndpoint,
+			STSEndpoint:     providerConfig.AwsSTSEndpoint,
+
+			CreateRepositories: providerConfig.AwsCreateRepositories,
+			ImageTagMutability: providerConfig.AwsImageTagMutability,
+			ScanOnPush:         providerConfig.AwsScanOnPush,
+			LifecyclePolicy:    providerConfig.AwsLifecyclePolicy,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+
+	case "registry", "static":
+		password, err := config.ReadSecret(
+			providerConfig.Password,
+			providerConfig.PasswordEnv,
+			providerConfig.PasswordFile,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		identityToken, err := config.ReadSecret(
+			providerConfig.IdentityToken,
+			providerConfig.IdentityTokenEnv,
+			providerConfig.IdentityTokenFile,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		provider, err := registry.New(
+			providerConfig.Registry,
+			providerConfig.Username,
+			password,
+			identityToken,
+		)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+
+	case "dockerconfig":
+		provider, err := dockerconfig.New(providerConfig.DockerConfig)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+
+	case "gcp":
+		key, err := config.ReadSecret(
+			providerConfig.GcpKey,
+			providerConfig.GcpKeyEnv,
+			providerConfig.GcpKeyFile,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		provider, err := gcp.New(key, providerConfig.GcpTokenURL)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+
+	case "azure":
+		if providerConfig.AzureClientID == "" {
+			password, err := config.ReadSecret(
+				providerConfig.Password,
+				providerConfig.PasswordEnv,
+				providerConfig.PasswordFile,
+			)
+			if err != nil {
+				return nil, err
+			}
+			provider, err := azure.NewAdmin(providerConfig.Registry, providerConfig.Username, password)
+			if err != nil {
+				return nil, err
+			}
+			return provider, nil
+		}
+
+		secret, err := config.ReadSecret(
+			providerConfig.AzureClientSecret,
+			providerConfig.AzureClientSecretEnv,
+			providerConfig.AzureClientSecretFile,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		provider, err := azure.NewServicePrincipal(
+			providerConfig.AzureTenantID,
+			providerConfig.AzureClientID,
+			secret,
+			providerConfig.AzureAuthority,
+			providerConfig.AzureRegistryEndpoint,
 		)
 		if err != nil {
 			return nil, err
@@ -62,6 +190,62 @@ func (c *cheopsImpl) initGitProvider(providerConfig *types.GitProviderConfig) (t
 	return provider, nil
 }
 
+func initStorageProvider(providerConfig *types.StorageProviderConfig) (types.StorageProvider, error) {
+	switch providerConfig.Type {
+	case "s3":
+		secretAccessKey, err := config.ReadSecret(
+			providerConfig.AwsSecretAccessKey,
+			providerConfig.AwsSecretAccessKeyEnv,
+			providerConfig.AwsSecretAccessKeyFile,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		provider, err := aws.NewS3(&aws.Config{
+			Region:          providerConfig.AwsRegion,
+			AccessKeyID:     providerConfig.AwsAccessKeyID,
+			SecretAccessKey: secretAccessKey,
+			SessionToken:    providerConfig.AwsSessionToken,
+			Profile:         providerConfig.AwsProfile,
+			RoleARN:         providerConfig.AwsRoleARN,
+			ExternalID:      providerConfig.AwsExternalID,
+			STSEndpoint:     providerConfig.AwsSTSEndpoint,
+			S3Endpoint:      providerConfig.S3Endpoint,
+			S3PathStyle:     providerConfig.S3PathStyle,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+
+	default:
+		return nil, errors.New("Unsupported provider: " + providerConfig.Type)
+	}
+}
+
+// initEngine creates the client of the container engine builds run on
+func initEngine(config *types.GeneralConfig) (types.Engine, error) {
+	switch config.Engine {
+	case "", "docker":
+		engine, err := docker.New(&config.Docker)
+		if err != nil {
+			return nil, err
+		}
+		return engine, nil
+
+	case "podman":
+		engine, err := docker.NewPodman(&config.Podman)
+		if err != nil {
+			return nil, err
+		}
+		return engine, nil
+
+	default:
+		return nil, errors.New("Unsupported engine: " + config.Engine)
+	}
+}
+
 func New() types.Cheops {
 	config, err := config.LoadConfig()
 	if err != nil {
@@ -72,6 +256,52 @@ func New() types.Cheops {
 	c.config = config
 	c.gitProviders = make(map[string]types.GitProvider)
 	c.dockerCredsProviders = make(map[string]types.DockerCredsProvider)
+	c.storageProviders = make(map[string]types.StorageProvider)
+	c.webhooks = make(map[string]types.WebhookFunc)
+	c.running = make(map[string]context.CancelFunc)
+
+	dataDir := config.General.DataDir
+	if dataDir == "" {
+		dataDir = "data"
+	}
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		log.WithFields(log.Fields{
+			"directory": dataDir,
+			"error":     err,
+		}).Fatal("Can't create data directory")
+	}
+
+	c.store, err = store.New(filepath.Join(dataDir, "cheops.db"))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"directory": dataDir,
+			"error":     err,
+		}).Fatal("Can't open build store")
+	}
+
+	c.logs, err = buildlog.NewManager(filepath.Join(dataDir, "logs"))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"directory": dataDir,
+			"error":     err,
+		}).Fatal("Can't create log directory")
+	}
+	c.artifacts, err = artifacts.NewStore(filepath.Join(dataDir, "artifacts"))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"directory": dataDir,
+			"error":     err,
+		}).Fatal("Can't create artifacts directory")
+	}
+	go c.pruneHistoryLoop()
+
+	c.engine, err = initEngine(&config.General)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"engine": config.General.Engine,
+			"error":  err,
+		}).Fatal("Can't create container engine client")
+	}
 
 	log.Debug("Initializing Git Providers")
 	for _, gitProvider := range config.Providers.Git {
@@ -95,7 +325,21 @@ func New() types.Cheops {
 		}
 	}
 
+	log.Debug("Initializing storage providers")
+	for _, storageProvider := range config.Providers.Storage {
+		c.storageProviders[storageProvider.Name], err = initStorageProvider(storageProvider)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"provider": storageProvider.Name,
+				"error":    err,
+			}).Fatal("Error loading provider")
+		}
+	}
+
+	c.registerAPI()
+
 	log.Debug("Loading builds")
+	registered := make(map[string]bool)
 	for _, repo := range config.Repos {
 		provider, ok := c.gitProviders[repo.Provider]
 		if !ok {
@@ -105,6 +349,12 @@ func New() types.Cheops {
 			}).Fatal("Unknown Git provider")
 		}
 
+		// Several entries may share a URL, the webhook is only needed once
+		if registered[repo.Provider+" "+repo.URL] {
+			continue
+		}
+		registered[repo.Provider+" "+repo.URL] = true
+
 		err := provider.RegisterRepo(repo)
 		if err != nil {
 			log.WithFields(log.Fields{
@@ -118,55 +368,105 @@ func New() types.Cheops {
 	return &c
 }
 
-func (c *cheopsImpl) procAction(action *types.Action) error {
+func actionStepName(action *types.Action) string {
+	if action.Container != "" {
+		return action.Type + " " + action.Container
+	}
+	if action.Image != "" {
+		return action.Type + " " + action.Image
+	}
+	if action.Bucket != "" {
+		return action.Type + " " + action.Bucket
+	}
+	return action.Type
+}
+
+func (c *cheopsImpl) procAction(ctx context.Context, ctxt *types.BuildContext, action *types.Action, out io.Writer) error {
 	log.WithFields(log.Fields{
 		"type": action.Type,
 	}).Debug("Performing action")
 
 	switch action.Type {
 	case "push":
-		provider, ok := c.dockerCredsProviders[action.Provider]
-		if !ok {
-			return errors.New("Unknown provider: " + action.Provider)
-		}
+		return c.pushImages(ctx, ctxt, action, out)
 
-		log.WithFields(log.Fields{
-			"provider": action.Provider,
-		}).Debug("Getting Docker credentials")
-		creds, err := provider.GetCredentials()
+	case "exec":
+		creds, err := c.pullCredentials(ctxt.Build, []string{action.Image})
 		if err != nil {
 			return err
 		}
 
-		err = docker.PushImage(action.Image, creds)
+		step := actionStepName(action)
+		err = c.engine.RunContainer(ctx, &types.RunOptions{
+			Image:       action.Image,
+			Commands:    action.Commands,
+			Credentials: creds[docker.RegistryHost(action.Image)],
+			Artifacts:   action.Artifacts,
+			CopyArtifact: func(path string, archive io.Reader) error {
+				return c.storeArtifacts(ctxt.Record, step, archive)
+			},
+		}, out)
 		if err != nil {
 			return err
 		}
 
-	case "exec":
-		err := docker.RunContainer(action.Image, action.Commands, nil)
-		if err != nil {
-			return err
-		}
+	case "upload":
+		return c.uploadFiles(ctx, ctxt, action, out)
 	}
 
 	return nil
 }
 
-func loadBuild(repoDir string, repo *types.Repository, commit *types.CommitInfo) (*types.Build, error) {
-	tmpl, err := template.ParseFiles(repoDir + "/cheops.yaml")
+// configData is the data of cheops.yaml templates. Unlike a map, a struct
+// makes the fields of notifier templates evaluated by mistake, like
+// {{.Build.ID}}, fail instead of rendering empty.
+type configData struct {
+	Secrets    map[string]interface{}
+	Commit     string
+	Repository string
+	Branch     string
+	Params     map[string]string
+	CommitInfo *types.CommitInfo
+}
+
+// yamlQuote returns a value as a YAML flow scalar or collection, so that
+// free text such as commit messages can't break or inject into cheops.yaml
+func yamlQuote(value interface{}) (string, error) {
+	buf := bytes.Buffer{}
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// loadBuild renders cheops.yaml as a text/template and returns the build of
+// the branch. Values that may hold free text, like the CommitInfo messages
+// and authors, must go through quote, e.g. {{quote .CommitInfo.Message}}.
+// Notifier templates are rendered later with the build event, so they are
+// written as string literals the first pass outputs as they are, e.g.
+// message: {{quote "Build {{.Build.ID}} {{.Build.Status}}"}}.
+func loadBuild(repoDir string, repo *types.Repository, commit *types.CommitInfo, params map[string]string) (*types.Build, error) {
+	tmpl, err := template.New("cheops.yaml").
+		Funcs(template.FuncMap{"quote": yamlQuote}).
+		ParseFiles(filepath.Join(repoDir, "cheops.yaml"))
 	if err != nil {
 		return nil, err
 	}
 
 	buf := bytes.Buffer{}
-	data := map[string]interface{}{
-		"Secrets":    repo.Secrets,
-		"Commit":     commit.ID,
-		"Repository": commit.RepoURL,
-		"Branch":     commit.Branch,
+	data := &configData{
+		Secrets:    repo.Secrets,
+		Commit:     commit.ID,
+		Repository: commit.RepoURL,
+		Branch:     commit.Branch,
+		Params:     params,
+		CommitInfo: commit,
+	}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
 	}
-	tmpl.Execute(&buf, &data)
 
 	configBytes := buf.Bytes()
 	log.WithFields(log.Fields{
@@ -182,22 +482,93 @@ func loadBuild(repoDir string, repo *types.Repository, commit *types.CommitInfo)
 		return nil, err
 	}
 
-	var build *types.Build
-	for _, build = range config.Builds {
-		if build.Branch == commit.Branch {
-			break
+	for _, build := range config.Builds {
+		if matchBranch(build.Branch, commit.Branch) {
+			return build, nil
+		}
+	}
+
+	return nil, errors.New("No matching branches")
+}
+
+func newBuildID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// startBuild prepares and executes a build in the background and returns
+// its ID right away
+func (c *cheopsImpl) startBuild(repo *types.Repository, record *types.BuildRecord) string {
+	commit := record.Commit
+	log.WithFields(log.Fields{
+		"build":  record.ID,
+		"repo":   commit.RepoURL,
+		"branch": commit.Branch,
+		"commit": commit.ID,
+	}).Info("Starting build")
+	c.saveRecord(record)
+
+	ctx := c.trackBuild(record.ID)
+	go func() {
+		defer c.untrackBuild(record.ID)
+
+		record.Status = types.StatusRunning
+		record.StartedAt = time.Now()
+		c.saveRecord(record)
+
+		buildLog := c.createLog(record.ID)
+		ctxt, err := c.GetBuildContext(ctx, repo, record, buildLog)
+		if err == nil {
+			c.notify(ctxt.Build, record, types.EventStart)
+			err = c.Execute(ctxt)
+		}
+		if ctx.Err() != nil {
+			err = errBuildCancelled
 		}
+		c.finishBuild(record, err)
+		c.closeLog(buildLog, record.ID)
+		if ctxt != nil {
+			c.notify(ctxt.Build, record, finishEvent(record))
+		}
+	}()
+
+	return record.ID
+}
+
+// fillCommitInfo completes the commit details the provider didn't supply,
+// e.g. for builds triggered through the API, from the cloned repository
+func fillCommitInfo(commit *types.CommitInfo, repoDir string) {
+	if commit.Message != "" && len(commit.Commits) > 0 {
+		return
 	}
-	if build == nil {
-		return nil, errors.New("No matching branches")
+
+	head, err := git.ReadCommit(repoDir, commit.ID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"commit": commit.ID,
+			"error":  err,
+		}).Warn("Can't read commit details")
+		return
 	}
 
-	return build, nil
+	if commit.Message == "" {
+		commit.Message = head.Message
+		commit.Author = head.Author
+		commit.Timestamp = head.Timestamp
+	}
+	if len(commit.Commits) == 0 {
+		commit.Commits = []*types.Commit{head}
+	}
 }
 
-func (c *cheopsImpl) GetBuildContext(repo *types.Repository, commit *types.CommitInfo) (*types.BuildContext, error) {
+func (c *cheopsImpl) GetBuildContext(ctx context.Context, repo *types.Repository, record *types.BuildRecord, buildLog types.BuildLog) (*types.BuildContext, error) {
+	commit := record.Commit
 	log.WithFields(log.Fields{
-		"repo": repo.URL,
+		"build": record.ID,
+		"repo":  repo.URL,
 	}).Debug("Preparing build context")
 
 	cloneDir, err := ioutil.TempDir("/tmp", "cheops")
@@ -209,8 +580,10 @@ func (c *cheopsImpl) GetBuildContext(repo *types.Repository, commit *types.Commi
 		return nil, err
 	}
 
+	step := c.startStep(record, "clone")
 	provider := c.gitProviders[repo.Provider]
-	err = provider.Clone(commit, cloneDir)
+	err = provider.Clone(commit, cloneDir, stepLog(buildLog, "clone"))
+	c.finishStep(record, step, err)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"repository": repo.URL,
@@ -219,7 +592,11 @@ func (c *cheopsImpl) GetBuildContext(repo *types.Repository, commit *types.Commi
 		return nil, err
 	}
 
-	b, err := loadBuild(cloneDir, repo, commit)
+	fillCommitInfo(commit, cloneDir)
+
+	step = c.startStep(record, "load")
+	b, err := loadBuild(cloneDir, repo, commit, record.Params)
+	c.finishStep(record, step, err)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"repository": repo.URL,
@@ -227,38 +604,83 @@ func (c *cheopsImpl) GetBuildContext(repo *types.Repository, commit *types.Commi
 		}).Error("Can't load build")
 		return nil, err
 	}
+	record.Build = b.Name
 
 	return &types.BuildContext{
+		Context: ctx,
+		ID:      record.ID,
 		Build:   b,
 		Commit:  commit,
+		Params:  record.Params,
 		RepoDir: cloneDir,
+		Record:  record,
+		Log:     buildLog,
+		Secrets: repo.Secrets,
+		SSH:     repo.SSH,
 	}, nil
 }
 
 func (c *cheopsImpl) Execute(ctxt *types.BuildContext) error {
 	log.WithFields(log.Fields{
+		"build":  ctxt.ID,
 		"repo":   ctxt.Commit.RepoURL,
 		"branch": ctxt.Commit.Branch,
 		"commit": ctxt.Commit.ID,
 	}).Debug("Executing task")
 
+	ctx := ctxt.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
 	for _, container := range ctxt.Build.Containers {
+		if ctx.Err() != nil {
+			return errBuildCancelled
+		}
+
 		log.WithFields(log.Fields{
 			"container": container.Tag,
 		}).Debug("Building image")
-		tags := []string{container.Tag}
+		tags := containerTags(container)
 
 		dockerfile := container.Dockerfile
 		if dockerfile == "" {
 			dockerfile = "Dockerfile"
 		}
 
-		context := container.Context
-		if context == "" {
-			context = "."
+		contextDir := container.Context
+		if contextDir == "" {
+			contextDir = "."
 		}
 
-		err := docker.BuildImage(ctxt.RepoDir, dockerfile, tags, container.Args)
+		step := c.startStep(ctxt.Record, "build "+container.Tag)
+		creds, err := c.containerCredentials(ctxt.Build, filepath.Join(ctxt.RepoDir, dockerfile), container)
+		var secrets map[string]string
+		if err == nil {
+			secrets, err = buildSecrets(container.Secrets, ctxt.Secrets)
+		}
+		var ssh []string
+		if err == nil {
+			ssh, err = buildSSH(container.SSH, ctxt.SSH)
+		}
+		if err == nil {
+			err = c.buildImages(ctx, &types.BuildOptions{
+				ContextDir:  ctxt.RepoDir,
+				Dockerfile:  dockerfile,
+				Tags:        tags,
+				Args:        container.Args,
+				Credentials: creds,
+				BuildKit:    container.BuildKit,
+				Secrets:     secrets,
+				SSH:         ssh,
+				CacheFrom:   container.CacheFrom,
+				CacheTo:     container.CacheTo,
+			}, container.Platforms, stepLog(ctxt.Log, step.Name))
+		}
+		if err == nil {
+			err = c.exportArtifacts(ctx, ctxt.Record, step.Name, containerImage(container), container.Artifacts)
+		}
+		c.finishStep(ctxt.Record, step, err)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"container": container.Tag,
@@ -269,7 +691,13 @@ func (c *cheopsImpl) Execute(ctxt *types.BuildContext) error {
 	}
 
 	for _, action := range ctxt.Build.Actions {
-		err := c.procAction(action)
+		if ctx.Err() != nil {
+			return errBuildCancelled
+		}
+
+		step := c.startStep(ctxt.Record, actionStepName(action))
+		err := c.procAction(ctx, ctxt, action, stepLog(ctxt.Log, step.Name))
+		c.finishStep(ctxt.Record, step, err)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"action": action.Type,
@@ -0,0 +1,137 @@
+package cheops
+
+import (
+	"cheops/types"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// loadTestBuild loads a build of main from config
+func loadTestBuild(t *testing.T, config string, commit *types.CommitInfo) (*types.Build, error) {
+	dir, err := ioutil.TempDir("", "cheops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "cheops.yaml"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &types.Repository{Secrets: map[string]interface{}{"token": "s3&cr3t"}}
+	return loadBuild(dir, repo, commit, map[string]string{"version": "1.2"})
+}
+
+func TestLoadBuildCommitInfo(t *testing.T) {
+	config := `builds:
+  - branch: main
+    actions:
+      - type: exec
+        image: alpine:{{.Params.version}}
+        commands:
+          - echo {{.Secrets.token}} {{.Branch}}
+          - {{quote .CommitInfo.Message}}
+          - {{quote .CommitInfo.Author.Name}}
+        artifacts: {{quote (index .CommitInfo.Commits 0).Modified}}
+`
+	commit := &types.CommitInfo{
+		ID:      "abc",
+		Branch:  "main",
+		Message: "Don't build it's\nbranch: other\n  - \"quoted\"",
+		Author:  &types.Person{Name: "O'Cat <octo>"},
+		Commits: []*types.Commit{{Modified: []string{"a.go", "b & c.go"}}},
+	}
+
+	build, err := loadTestBuild(t, config, commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	action := build.Actions[0]
+	expected := []string{"echo s3&cr3t main", commit.Message, "O'Cat <octo>"}
+	if build.Branch != "main" || action.Image != "alpine:1.2" || !reflect.DeepEqual(action.Commands, expected) {
+		t.Error("Wrong build:", build.Branch, action.Image, action.Commands)
+	}
+	if !reflect.DeepEqual(action.Artifacts, commit.Commits[0].Modified) {
+		t.Error("Wrong artifacts:", action.Artifacts)
+	}
+}
+
+func TestLoadBuildNotifier(t *testing.T) {
+	server, payloads := newNotifierServer(t)
+	defer server.Close()
+
+	config := `builds:
+  - branch: main
+    notifiers:
+      - type: generic
+        url: ` + server.URL + `
+        message: {{quote "Build {{.Build.ID}} of {{.Build.Branch}} {{.Build.Status}}"}}
+`
+	commit := &types.CommitInfo{ID: "abc", Branch: "main"}
+	build, err := loadTestBuild(t, config, commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, cleanup := newTestCheops(t)
+	defer cleanup()
+
+	record := newBuildRecord("1234", commit, nil)
+	record.Status = types.StatusSuccess
+	c.notify(build, record, types.EventSuccess)
+
+	if payload := <-payloads; payload["message"] != "Build 1234 of main success" {
+		t.Error("Wrong message:", payload["message"])
+	}
+
+	// Without the literal, the first pass fails rather than dropping the
+	// fields of the build event
+	config = strings.Replace(config, `{{quote "Build {{.Build.ID}} of {{.Build.Branch}} {{.Build.Status}}"}}`, `"{{.Build.ID}} done"`, 1)
+	if _, err := loadTestBuild(t, config, commit); err == nil {
+		t.Error("Expected an error for a notifier template evaluated by the first pass")
+	}
+}
+
+func TestLoadBuildEmailNotifier(t *testing.T) {
+	config := `builds:
+  - branch: main
+    notifiers:
+      - type: email
+        to: [ops@example.com]
+        subject: {{quote "{{.Build.Branch}} {{.Build.Status}}"}}
+        message: {{quote ` + "`" + `Build {{.Build.ID}}{{range .LogTail}}
+{{.}}{{end}}` + "`" + `}}
+`
+	build, err := loadTestBuild(t, config, &types.CommitInfo{ID: "abc", Branch: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := build.Notifiers[0]
+	if notifier.Subject != "{{.Build.Branch}} {{.Build.Status}}" ||
+		notifier.Message != "Build {{.Build.ID}}{{range .LogTail}}\n{{.}}{{end}}" {
+		t.Errorf("Wrong templates: %q %q", notifier.Subject, notifier.Message)
+	}
+}
+
+// newNotifierServer returns a generic webhook stand-in and the payloads it
+// receives
+func newNotifierServer(t *testing.T) (*httptest.Server, <-chan map[string]interface{}) {
+	payloads := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		payloads <- payload
+	}))
+	return server, payloads
+}
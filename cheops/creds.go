@@ -0,0 +1,118 @@
+package cheops
+
+import (
+	"cheops/docker"
+	"cheops/types"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pullCredentials returns the credentials for the registries of the images,
+// keyed by registry host, from the first pull credentials provider of the
+// build that has them. Registries no provider has credentials for are left
+// out, so public images can still be pulled.
+func (c *cheopsImpl) pullCredentials(build *types.Build, images []string) (map[string]string, error) {
+	providers := []types.DockerCredsProvider{}
+	for _, name := range build.PullCreds {
+		provider, ok := c.dockerCredsProviders[name]
+		if !ok {
+			return nil, errors.New("Unknown provider: " + name)
+		}
+		providers = append(providers, provider)
+	}
+
+	credentials := map[string]string{}
+	for _, image := range images {
+		host := docker.RegistryHost(image)
+		if _, ok := credentials[host]; ok {
+			continue
+		}
+
+		for i, provider := range providers {
+			creds, err := provider.GetCredentials(host)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"provider": build.PullCreds[i],
+					"registry": host,
+					"error":    err,
+				}).Debug("No pull credentials from provider")
+				continue
+			}
+
+			credentials[host] = creds
+			break
+		}
+	}
+
+	return credentials, nil
+}
+
+// baseImageCredentials returns the pull credentials for the base images of
+// a Dockerfile
+func (c *cheopsImpl) baseImageCredentials(build *types.Build, dockerfile string, args map[string]*string) (map[string]string, error) {
+	if len(build.PullCreds) == 0 {
+		return nil, nil
+	}
+
+	images, err := docker.BaseImages(dockerfile, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.pullCredentials(build, images)
+}
+
+// containerCredentials returns the pull credentials of a container build, for
+// its base images and, with BuildKit, the registries of its caches
+func (c *cheopsImpl) containerCredentials(build *types.Build, dockerfile string, container *types.Container) (map[string]string, error) {
+	credentials, err := c.baseImageCredentials(build, dockerfile, container.Args)
+	if err != nil || !container.BuildKit || len(build.PullCreds) == 0 {
+		return credentials, err
+	}
+
+	caches := append(append([]string{}, container.CacheFrom...), container.CacheTo...)
+	cacheCredentials, err := c.pullCredentials(build, docker.CacheImages(caches))
+	if err != nil {
+		return nil, err
+	}
+
+	for host, creds := range cacheCredentials {
+		if _, ok := credentials[host]; !ok {
+			credentials[host] = creds
+		}
+	}
+	return credentials, nil
+}
+
+// buildSSH returns the docker build --ssh specs of the SSH sources of the
+// repository entry a container build forwards
+func buildSSH(ids []string, sources map[string]string) ([]string, error) {
+	specs := []string{}
+	for _, id := range ids {
+		source, ok := sources[id]
+		if !ok {
+			return nil, errors.New("Unknown SSH source: " + id)
+		}
+		if source == "" {
+			specs = append(specs, id)
+		} else {
+			specs = append(specs, id+"="+source)
+		}
+	}
+	return specs, nil
+}
+
+// buildSecrets returns the values of the repository secrets a container
+// build exposes, keyed by name
+func buildSecrets(names []string, secrets map[string]interface{}) (map[string]string, error) {
+	values := map[string]string{}
+	for _, name := range names {
+		value, ok := secrets[name].(string)
+		if !ok {
+			return nil, errors.New("Unknown secret: " + name)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
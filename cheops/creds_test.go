@@ -0,0 +1,87 @@
+package cheops
+
+import (
+	"cheops/types"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeCredsProvider has credentials for a single registry
+type fakeCredsProvider struct {
+	registry string
+	creds    string
+}
+
+func (p *fakeCredsProvider) GetCredentials(registry string) (string, error) {
+	if registry != p.registry {
+		return "", errors.New("No credentials for registry: " + registry)
+	}
+	return p.creds, nil
+}
+
+func TestPullCredentials(t *testing.T) {
+	c := &cheopsImpl{
+		dockerCredsProviders: map[string]types.DockerCredsProvider{
+			"ecr":  &fakeCredsProvider{"123.dkr.ecr.eu-west-1.amazonaws.com", "ecr-creds"},
+			"hub":  &fakeCredsProvider{"docker.io", "hub-creds"},
+			"hub2": &fakeCredsProvider{"docker.io", "other-hub-creds"},
+		},
+	}
+	build := &types.Build{PullCreds: []string{"ecr", "hub", "hub2"}}
+
+	creds, err := c.pullCredentials(build, []string{
+		"123.dkr.ecr.eu-west-1.amazonaws.com/base:latest",
+		"private/tools",
+		"ghcr.io/octo/public",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"123.dkr.ecr.eu-west-1.amazonaws.com": "ecr-creds",
+		"docker.io":                           "hub-creds",
+	}
+	if !reflect.DeepEqual(creds, expected) {
+		t.Error("Expected", expected, "got", creds)
+	}
+
+	build.PullCreds = []string{"missing"}
+	if _, err := c.pullCredentials(build, []string{"alpine"}); err == nil {
+		t.Error("Expected an error for an unknown provider")
+	}
+}
+
+func TestBaseImageCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cheops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dockerfile := filepath.Join(dir, "Dockerfile")
+	ioutil.WriteFile(dockerfile, []byte("FROM 123.dkr.ecr.eu-west-1.amazonaws.com/base\nRUN true\n"), 0644)
+
+	c := &cheopsImpl{
+		dockerCredsProviders: map[string]types.DockerCredsProvider{
+			"ecr": &fakeCredsProvider{"123.dkr.ecr.eu-west-1.amazonaws.com", "ecr-creds"},
+		},
+	}
+
+	creds, err := c.baseImageCredentials(&types.Build{PullCreds: []string{"ecr"}}, dockerfile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds["123.dkr.ecr.eu-west-1.amazonaws.com"] != "ecr-creds" {
+		t.Error("Wrong credentials:", creds)
+	}
+
+	creds, err = c.baseImageCredentials(&types.Build{}, filepath.Join(dir, "missing"), nil)
+	if err != nil || creds != nil {
+		t.Error("Expected no credentials without pull_creds:", creds, err)
+	}
+}
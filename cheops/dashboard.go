@@ -0,0 +1,28 @@
+package cheops
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// registerDashboard serves the web UI under /ui/. The UI itself is public,
+// the data it shows comes from the authenticated API.
+func (c *cheopsImpl) registerDashboard() {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+
+	http.Handle("/ui/", http.StripPrefix("/ui/", http.FileServer(http.FS(files))))
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/ui/", http.StatusFound)
+	})
+}
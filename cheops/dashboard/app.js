@@ -0,0 +1,459 @@
+(function () {
+  "use strict";
+
+  var tokenKey = "cheops-token";
+  var refreshInterval = 5000;
+  var timer = null;
+  var logSource = null;
+  var currentBuild = null;
+  // Incremented on every navigation, so that requests still in flight from
+  // the previous view are ignored
+  var generation = 0;
+
+  function $(id) {
+    return document.getElementById(id);
+  }
+
+  function token() {
+    return localStorage.getItem(tokenKey) || "";
+  }
+
+  function showError(message) {
+    var error = $("error");
+    error.textContent = message || "";
+    error.hidden = !message;
+  }
+
+  function api(method, path, body) {
+    var options = {
+      method: method,
+      headers: { "Authorization": "Bearer " + token() }
+    };
+    if (body !== undefined) {
+      options.headers["Content-Type"] = "application/json";
+      options.body = JSON.stringify(body);
+    }
+
+    return fetch(path, options).then(function (res) {
+      if (res.status === 401) {
+        localStorage.removeItem(tokenKey);
+        route();
+        showError("Invalid API token");
+        throw new Error("Invalid API token");
+      }
+      return res.json().then(function (data) {
+        if (!res.ok) {
+          throw new Error(data.error || res.statusText);
+        }
+        return data;
+      });
+    });
+  }
+
+  function cell(row, content) {
+    var td = document.createElement("td");
+    if (content instanceof Node) {
+      td.appendChild(content);
+    } else {
+      td.textContent = content === undefined ? "" : content;
+    }
+    row.appendChild(td);
+    return td;
+  }
+
+  function code(text) {
+    var el = document.createElement("code");
+    el.textContent = text;
+    return el;
+  }
+
+  function statusBadge(status) {
+    var el = document.createElement("span");
+    el.className = "status " + status;
+    el.textContent = status;
+    return el;
+  }
+
+  function isSet(time) {
+    return time && time.indexOf("0001-") !== 0;
+  }
+
+  function formatTime(time) {
+    return isSet(time) ? new Date(time).toLocaleString() : "";
+  }
+
+  function duration(start, end) {
+    if (!isSet(start)) {
+      return "";
+    }
+    var to = isSet(end) ? new Date(end) : new Date();
+    var seconds = Math.round((to - new Date(start)) / 1000);
+    if (seconds < 60) {
+      return seconds + "s";
+    }
+    return Math.floor(seconds / 60) + "m " + (seconds % 60) + "s";
+  }
+
+  function formatSize(size) {
+    var units = ["B", "kB", "MB", "GB"];
+    var i = 0;
+    while (size >= 1000 && i < units.length - 1) {
+      size /= 1000;
+      i++;
+    }
+    return (i ? size.toFixed(1) : size) + " " + units[i];
+  }
+
+  function shortCommit(commit) {
+    return commit && commit.id ? commit.id.substring(0, 8) : "";
+  }
+
+  function commitTitle(commit) {
+    return commit && commit.message ? commit.message.split("\n")[0] : "";
+  }
+
+  function personName(person) {
+    if (!person) {
+      return "";
+    }
+    return person.email ? person.name + " <" + person.email + ">" : person.name;
+  }
+
+  function stopUpdates() {
+    if (timer) {
+      clearTimeout(timer);
+      timer = null;
+    }
+    if (logSource) {
+      logSource.close();
+      logSource = null;
+    }
+  }
+
+  function schedule(fn) {
+    timer = setTimeout(fn, refreshInterval);
+  }
+
+  function showSection(id) {
+    ["login", "overview", "build"].forEach(function (section) {
+      $(section).hidden = section !== id;
+    });
+  }
+
+  function loadOverview() {
+    var gen = generation;
+    Promise.all([api("GET", "/api/repos"), api("GET", "/api/builds?limit=50")])
+      .then(function (results) {
+        if (gen !== generation) {
+          return;
+        }
+        showError();
+        renderRepos(results[0]);
+        renderBuilds(results[1]);
+      })
+      .catch(function (err) {
+        if (gen === generation) {
+          showError(err.message);
+        }
+      })
+      .then(function () {
+        if (gen === generation) {
+          schedule(loadOverview);
+        }
+      });
+  }
+
+  function renderRepos(repos) {
+    var tbody = $("repos");
+    tbody.textContent = "";
+    repos.forEach(function (repo) {
+      var row = document.createElement("tr");
+      cell(row, repo.url);
+      cell(row, repo.provider);
+      cell(row, repo.branches.join(", "));
+      tbody.appendChild(row);
+    });
+  }
+
+  function renderBuilds(builds) {
+    var tbody = $("builds");
+    tbody.textContent = "";
+    builds.forEach(function (build) {
+      var row = document.createElement("tr");
+      row.className = "link";
+      row.onclick = function () {
+        location.hash = "#build/" + build.id;
+      };
+      cell(row, code(build.id));
+      cell(row, statusBadge(build.status));
+      cell(row, build.repo_url);
+      cell(row, build.branch);
+      cell(row, code(shortCommit(build.commit)));
+      cell(row, formatTime(build.started_at || build.created_at));
+      cell(row, duration(build.started_at, build.finished_at));
+      tbody.appendChild(row);
+    });
+  }
+
+  function isRunning(build) {
+    return build.status === "pending" || build.status === "running";
+  }
+
+  function loadBuild(id) {
+    var gen = generation;
+    api("GET", "/api/builds/" + id)
+      .then(function (build) {
+        if (gen !== generation) {
+          return;
+        }
+        showError();
+        currentBuild = build;
+        renderBuild(build);
+        if (build.status === "skipped" && logSource) {
+          // Skipped builds have no log
+          logSource.close();
+          logSource = null;
+        }
+        if (isRunning(build)) {
+          schedule(function () {
+            loadBuild(id);
+          });
+        }
+      })
+      .catch(function (err) {
+        if (gen === generation) {
+          showError(err.message);
+        }
+      });
+  }
+
+  function renderBuild(build) {
+    $("build-id").textContent = build.id;
+    var status = $("build-status");
+    status.className = "status " + build.status;
+    status.textContent = build.status;
+
+    var commit = build.commit || {};
+    var info = $("build-info");
+    info.textContent = "";
+    [
+      ["Repository", build.repo_url],
+      ["Branch", build.branch],
+      ["Commit", commit.id],
+      ["Message", commitTitle(commit)],
+      ["Author", personName(commit.author)],
+      ["Pushed by", personName(commit.pusher)],
+      ["Changes", commit.compare_url, commit.compare_url],
+      ["Build", build.build],
+      ["Re-run of", build.rerun_of, "#build/" + build.rerun_of],
+      ["Webhook delivery", build.delivery_id],
+      ["Skipped by", build.skipped_by],
+      ["Created", formatTime(build.created_at)],
+      ["Duration", duration(build.started_at, build.finished_at)],
+      ["Error", build.error]
+    ].forEach(function (item) {
+      if (!item[1]) {
+        return;
+      }
+      var dt = document.createElement("dt");
+      dt.textContent = item[0];
+      var dd = document.createElement("dd");
+      if (item[2]) {
+        var link = document.createElement("a");
+        link.href = item[2];
+        link.textContent = item[1];
+        dd.appendChild(link);
+      } else {
+        dd.textContent = item[1];
+      }
+      info.appendChild(dt);
+      info.appendChild(dd);
+    });
+
+    $("cancel").disabled = !isRunning(build);
+
+    var images = $("images");
+    images.textContent = "";
+    (build.images || []).forEach(function (image) {
+      var row = document.createElement("tr");
+      cell(row, image.image);
+      cell(row, code(image.digest || ""));
+      images.appendChild(row);
+    });
+    $("images-section").hidden = !(build.images || []).length;
+
+    var artifacts = $("artifacts");
+    artifacts.textContent = "";
+    (build.artifacts || []).forEach(function (artifact) {
+      var row = document.createElement("tr");
+      var link = document.createElement("a");
+      link.href = "/api/builds/" + build.id + "/artifacts/" +
+        artifact.path.split("/").map(encodeURIComponent).join("/");
+      link.onclick = download;
+      link.textContent = artifact.path;
+      cell(row, link);
+      cell(row, artifact.step);
+      cell(row, formatSize(artifact.size));
+      artifacts.appendChild(row);
+    });
+    $("artifacts-section").hidden = !(build.artifacts || []).length;
+
+    var tbody = $("steps");
+    tbody.textContent = "";
+    (build.steps || []).forEach(function (step) {
+      var row = document.createElement("tr");
+      cell(row, step.name);
+      cell(row, statusBadge(step.status));
+      cell(row, duration(step.started_at, step.finished_at));
+      cell(row, step.error);
+      tbody.appendChild(row);
+    });
+  }
+
+  // buildToken returns a short-lived token for the log stream and artifacts
+  // of a build, which unlike the API token can go in URLs
+  function buildToken(id) {
+    return api("POST", "/api/builds/" + id + "/token").then(function (res) {
+      return res.token;
+    });
+  }
+
+  function download(event) {
+    event.preventDefault();
+    var url = event.currentTarget.href;
+    var build = currentBuild;
+    if (!build) {
+      return;
+    }
+    buildToken(build.id)
+      .then(function (buildToken) {
+        location.href = url + "?token=" + encodeURIComponent(buildToken);
+      })
+      .catch(function (err) {
+        showError(err.message);
+      });
+  }
+
+  function streamLog(id) {
+    var gen = generation;
+    var pre = $("log");
+    pre.textContent = "";
+
+    buildToken(id)
+      .then(function (buildToken) {
+        if (gen === generation) {
+          openLogStream(id, buildToken);
+        }
+      })
+      .catch(function (err) {
+        if (gen === generation) {
+          showError(err.message);
+        }
+      });
+  }
+
+  function openLogStream(id, buildToken) {
+    var gen = generation;
+    var pre = $("log");
+
+    var url = "/api/builds/" + id + "/log/stream?token=" + encodeURIComponent(buildToken);
+    var source = new EventSource(url);
+    logSource = source;
+    source.onopen = function () {
+      // The whole log is sent again when EventSource reconnects
+      pre.textContent = "";
+    };
+    source.onerror = function () {
+      if (source.readyState !== EventSource.CLOSED) {
+        return;
+      }
+      // The build token expired before EventSource reconnected, start over
+      // with a new one
+      setTimeout(function () {
+        if (gen === generation && logSource === source) {
+          streamLog(id);
+        }
+      }, refreshInterval);
+    };
+    source.onmessage = function (event) {
+      var follow = pre.scrollTop + pre.clientHeight >= pre.scrollHeight - 4;
+      pre.appendChild(document.createTextNode(event.data + "\n"));
+      if (follow) {
+        pre.scrollTop = pre.scrollHeight;
+      }
+    };
+    source.addEventListener("end", function () {
+      // Don't let EventSource reconnect and replay the whole log
+      source.close();
+    });
+  }
+
+  function rerun() {
+    var build = currentBuild;
+    if (!build) {
+      return;
+    }
+    api("POST", "/api/builds/" + build.id + "/rerun")
+      .then(function (res) {
+        location.hash = "#build/" + res.id;
+      })
+      .catch(function (err) {
+        showError(err.message);
+      });
+  }
+
+  function cancel() {
+    if (!currentBuild) {
+      return;
+    }
+    api("POST", "/api/builds/" + currentBuild.id + "/cancel")
+      .then(function () {
+        loadBuild(currentBuild.id);
+      })
+      .catch(function (err) {
+        showError(err.message);
+      });
+  }
+
+  function route() {
+    generation++;
+    stopUpdates();
+    showError();
+    currentBuild = null;
+
+    if (!token()) {
+      showSection("login");
+      return;
+    }
+
+    var match = location.hash.match(/^#build\/([0-9a-zA-Z]+)$/);
+    if (match) {
+      showSection("build");
+      $("build-id").textContent = match[1];
+      $("steps").textContent = "";
+      $("images-section").hidden = true;
+      $("artifacts-section").hidden = true;
+      loadBuild(match[1]);
+      streamLog(match[1]);
+    } else {
+      showSection("overview");
+      loadOverview();
+    }
+  }
+
+  $("login-form").onsubmit = function (event) {
+    event.preventDefault();
+    localStorage.setItem(tokenKey, $("token").value);
+    $("token").value = "";
+    route();
+  };
+  $("logout").onclick = function () {
+    localStorage.removeItem(tokenKey);
+    route();
+  };
+  $("rerun").onclick = rerun;
+  $("cancel").onclick = cancel;
+  window.onhashchange = route;
+
+  route();
+})();
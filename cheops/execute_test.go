@@ -0,0 +1,626 @@
+package cheops
+
+import (
+	"archive/tar"
+	"bytes"
+	"cheops/artifacts"
+	"cheops/types"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeEngine keeps images in memory and records the builds and runs
+type fakeEngine struct {
+	mutex sync.Mutex
+	// images maps the image references to image IDs
+	images map[string]string
+	builds []*types.BuildOptions
+	runs   []*types.RunOptions
+	pushes []string
+	// failBuild is the tag whose build fails
+	failBuild string
+	// blockRun, when set, receives a value when a container starts, which
+	// then runs until the build is cancelled
+	blockRun chan struct{}
+	// runStatus is the exit status of the commands of containers
+	runStatus int
+	// noDigest makes pushes return no digest
+	noDigest bool
+}
+
+// tarArchive returns a tar archive of files, keyed by path
+func tarArchive(files map[string]string) io.Reader {
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bytes.Buffer{}
+	writer := tar.NewWriter(&buf)
+	for _, name := range names {
+		writer.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg})
+		writer.Write([]byte(files[name]))
+	}
+	writer.Close()
+	return &buf
+}
+
+func newFakeEngine() *fakeEngine {
+	return &fakeEngine{images: map[string]string{}}
+}
+
+func (e *fakeEngine) BuildImage(ctx context.Context, options *types.BuildOptions, out io.Writer) (string, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.builds = append(e.builds, options)
+	fmt.Fprintln(out, "Step 1/1 : FROM scratch")
+	if options.Tags[0] == e.failBuild {
+		return "", errors.New("The command '/bin/sh -c false' returned a non-zero code: 1")
+	}
+
+	id := fmt.Sprintf("sha256:%d", len(e.builds))
+	for _, tag := range options.Tags {
+		e.images[tag] = id
+	}
+	return id, nil
+}
+
+func (e *fakeEngine) PushImage(ctx context.Context, image, credentials string, out io.Writer) (string, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	id, ok := e.images[image]
+	if !ok {
+		return "", errors.New("An image does not exist locally with the tag: " + image)
+	}
+	e.pushes = append(e.pushes, image+" "+credentials)
+	if e.noDigest {
+		return "", nil
+	}
+	return "sha256:digest-" + id[7:], nil
+}
+
+func (e *fakeEngine) PushManifestList(ctx context.Context, image string, manifests []string, credentials string, out io.Writer) (string, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.pushes = append(e.pushes, image+" "+strings.Join(manifests, ",")+" "+credentials)
+	return fmt.Sprintf("sha256:list-%d", len(manifests)), nil
+}
+
+func (e *fakeEngine) TagImage(ctx context.Context, source, target string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	id, ok := e.images[source]
+	if !ok {
+		return errors.New("No such image: " + source)
+	}
+	e.images[target] = id
+	return nil
+}
+
+func (e *fakeEngine) RunContainer(ctx context.Context, options *types.RunOptions, out io.Writer) error {
+	e.mutex.Lock()
+	e.runs = append(e.runs, options)
+	e.mutex.Unlock()
+
+	if e.blockRun != nil {
+		e.blockRun <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if e.runStatus != 0 {
+		return fmt.Errorf("Commands exited with status %d", e.runStatus)
+	}
+
+	for _, path := range options.Artifacts {
+		archive := tarArchive(map[string]string{filepath.Base(path): "output of " + options.Image})
+		if err := options.CopyArtifact(path, archive); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *fakeEngine) CopyFromImage(ctx context.Context, image, path string) (io.ReadCloser, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if _, ok := e.images[image]; !ok {
+		return nil, errors.New("No such image: " + image)
+	}
+	archive := tarArchive(map[string]string{filepath.Base(path): "content of " + image})
+	return ioutil.NopCloser(archive), nil
+}
+
+func (e *fakeEngine) RemoveImage(ctx context.Context, image string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	delete(e.images, image)
+	return nil
+}
+
+func newExecuteTest(t *testing.T, build *types.Build) (*cheopsImpl, *fakeEngine, *types.BuildContext, func()) {
+	dir, err := ioutil.TempDir("", "cheops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM ghcr.io/octo/base\n"), 0644)
+
+	artifactStore, err := artifacts.NewStore(filepath.Join(dir, "artifacts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := newFakeEngine()
+	c := &cheopsImpl{
+		engine:    engine,
+		artifacts: artifactStore,
+		dockerCredsProviders: map[string]types.DockerCredsProvider{
+			"ecr":  &fakeCredsProvider{"123.dkr.ecr.eu-west-1.amazonaws.com", "ecr-creds"},
+			"ghcr": &fakeCredsProvider{"ghcr.io", "ghcr-creds"},
+		},
+	}
+
+	commit := &types.CommitInfo{ID: "abc", Branch: "main", RepoURL: "https://example.com/repo.git"}
+	ctxt := &types.BuildContext{
+		Context: context.Background(),
+		ID:      "1234",
+		Build:   build,
+		Commit:  commit,
+		RepoDir: dir,
+		Record:  newBuildRecord("1234", commit, nil),
+	}
+
+	return c, engine, ctxt, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func stepStatuses(record *types.BuildRecord) []string {
+	statuses := []string{}
+	for _, step := range record.Steps {
+		statuses = append(statuses, step.Name+": "+step.Status)
+	}
+	return statuses
+}
+
+func TestExecute(t *testing.T) {
+	build := &types.Build{
+		PullCreds: []string{"ghcr"},
+		Containers: []*types.Container{
+			{Tag: "tools:latest"},
+			{Name: "web", Tag: "ghcr.io/octo/web:1.2", Tags: []string{"ghcr.io/octo/web:latest"}},
+		},
+		Actions: []*types.Action{
+			{Type: "push", Container: "web", Provider: "ghcr", Registries: []*types.PushRegistry{
+				{Repository: "ghcr.io/octo/web"},
+				{Repository: "123.dkr.ecr.eu-west-1.amazonaws.com/web", Provider: "ecr"},
+			}},
+			{Type: "exec", Image: "ghcr.io/octo/deploy", Commands: []string{"deploy"}},
+		},
+	}
+
+	c, engine, ctxt, cleanup := newExecuteTest(t, build)
+	defer cleanup()
+
+	if err := c.Execute(ctxt); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedSteps := []string{
+		"build tools:latest: success",
+		"build ghcr.io/octo/web:1.2: success",
+		"push web: success",
+		"exec ghcr.io/octo/deploy: success",
+	}
+	if statuses := stepStatuses(ctxt.Record); !reflect.DeepEqual(statuses, expectedSteps) {
+		t.Error("Wrong steps:", statuses)
+	}
+
+	if tags := engine.builds[1].Tags; !reflect.DeepEqual(tags, []string{"ghcr.io/octo/web:1.2", "ghcr.io/octo/web:latest"}) {
+		t.Error("Wrong tags:", tags)
+	}
+	if creds := engine.builds[1].Credentials; creds["ghcr.io"] != "ghcr-creds" {
+		t.Error("Wrong base image credentials:", creds)
+	}
+
+	expectedPushes := []string{
+		"ghcr.io/octo/web:1.2 ghcr-creds",
+		"ghcr.io/octo/web:latest ghcr-creds",
+		"123.dkr.ecr.eu-west-1.amazonaws.com/web:1.2 ecr-creds",
+		"123.dkr.ecr.eu-west-1.amazonaws.com/web:latest ecr-creds",
+	}
+	if !reflect.DeepEqual(engine.pushes, expectedPushes) {
+		t.Error("Wrong pushes:", engine.pushes)
+	}
+	if _, ok := engine.images["123.dkr.ecr.eu-west-1.amazonaws.com/web:1.2"]; ok {
+		t.Error("Push tag not removed")
+	}
+
+	if len(ctxt.Record.Images) != 4 || ctxt.Record.Images[3].Digest != "sha256:digest-2" {
+		t.Error("Wrong images:", ctxt.Record.Images)
+	}
+
+	run := engine.runs[0]
+	if run.Image != "ghcr.io/octo/deploy" || run.Credentials != "ghcr-creds" || run.Commands[0] != "deploy" {
+		t.Error("Wrong run:", run)
+	}
+}
+
+func TestExecuteBuildFailure(t *testing.T) {
+	build := &types.Build{
+		Containers: []*types.Container{{Tag: "app:latest"}},
+		Actions:    []*types.Action{{Type: "push", Image: "app:latest", Provider: "ghcr"}},
+	}
+
+	c, engine, ctxt, cleanup := newExecuteTest(t, build)
+	defer cleanup()
+	engine.failBuild = "app:latest"
+
+	if err := c.Execute(ctxt); err == nil {
+		t.Fatal("Expected the build to fail")
+	}
+	if statuses := stepStatuses(ctxt.Record); !reflect.DeepEqual(statuses, []string{"build app:latest: failed"}) {
+		t.Error("Wrong steps:", statuses)
+	}
+	if len(engine.pushes) != 0 {
+		t.Error("Pushed after a failed build:", engine.pushes)
+	}
+}
+
+func TestExecuteCancelled(t *testing.T) {
+	build := &types.Build{
+		Actions: []*types.Action{
+			{Type: "exec", Image: "alpine", Commands: []string{"sleep 3600"}},
+			{Type: "exec", Image: "alpine", Commands: []string{"echo never"}},
+		},
+	}
+
+	c, engine, ctxt, cleanup := newExecuteTest(t, build)
+	defer cleanup()
+	engine.blockRun = make(chan struct{}, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctxt.Context = ctx
+
+	done := make(chan error)
+	go func() {
+		done <- c.Execute(ctxt)
+	}()
+
+	// Cancel once the first container runs
+	<-engine.blockRun
+	cancel()
+
+	if err := <-done; err == nil {
+		t.Fatal("Expected the build to be cancelled")
+	}
+	if len(engine.runs) != 1 {
+		t.Error("Expected a single container run, got", len(engine.runs))
+	}
+}
+
+func TestProcActionUnknownProvider(t *testing.T) {
+	build := &types.Build{Containers: []*types.Container{{Tag: "app:latest"}}}
+	c, engine, ctxt, cleanup := newExecuteTest(t, build)
+	defer cleanup()
+	engine.images["app:latest"] = "sha256:1"
+
+	err := c.procAction(context.Background(), ctxt, &types.Action{Type: "push", Container: "app:latest", Provider: "missing"}, ioutil.Discard)
+	if err == nil || err.Error() != "Unknown provider: missing" {
+		t.Error("Wrong error:", err)
+	}
+
+	build.PullCreds = []string{"missing"}
+	err = c.procAction(context.Background(), ctxt, &types.Action{Type: "exec", Image: "alpine"}, ioutil.Discard)
+	if err == nil {
+		t.Error("Expected an error for an unknown pull credentials provider")
+	}
+}
+
+func TestExecuteBuildKit(t *testing.T) {
+	build := &types.Build{
+		PullCreds: []string{"ecr"},
+		Containers: []*types.Container{{
+			Tag:       "app:latest",
+			BuildKit:  true,
+			Secrets:   []string{"npm_token"},
+			SSH:       []string{"default", "deploy"},
+			CacheFrom: []string{"type=registry,ref=123.dkr.ecr.eu-west-1.amazonaws.com/app:cache"},
+		}},
+	}
+
+	c, engine, ctxt, cleanup := newExecuteTest(t, build)
+	defer cleanup()
+	ctxt.Secrets = map[string]interface{}{"npm_token": "s3cr3t", "other": "unused"}
+	ctxt.SSH = map[string]string{"default": "", "deploy": "/etc/cheops/deploy_key"}
+
+	if err := c.Execute(ctxt); err != nil {
+		t.Fatal(err)
+	}
+
+	options := engine.builds[0]
+	if !options.BuildKit || !reflect.DeepEqual(options.Secrets, map[string]string{"npm_token": "s3cr3t"}) ||
+		!reflect.DeepEqual(options.SSH, []string{"default", "deploy=/etc/cheops/deploy_key"}) {
+		t.Error("Wrong build options:", options)
+	}
+	if creds := options.Credentials["123.dkr.ecr.eu-west-1.amazonaws.com"]; creds != "ecr-creds" {
+		t.Error("Wrong cache credentials:", options.Credentials)
+	}
+
+	build.Containers[0].Secrets = []string{"missing"}
+	ctxt.Record = newBuildRecord("1235", ctxt.Commit, nil)
+	if err := c.Execute(ctxt); err == nil || err.Error() != "Unknown secret: missing" {
+		t.Error("Wrong error:", err)
+	}
+
+	// SSH sources must be those of the repository entry
+	build.Containers[0].Secrets = nil
+	build.Containers[0].SSH = []string{"id=/root/.ssh/id_rsa"}
+	ctxt.Record = newBuildRecord("1236", ctxt.Commit, nil)
+	if err := c.Execute(ctxt); err == nil || err.Error() != "Unknown SSH source: id=/root/.ssh/id_rsa" {
+		t.Error("Wrong error:", err)
+	}
+}
+
+func TestExecutePlatforms(t *testing.T) {
+	build := &types.Build{
+		Containers: []*types.Container{
+			{Name: "web", Tag: "ghcr.io/octo/web:1.2", Platforms: []string{"linux/amd64", "linux/arm64"}},
+		},
+		Actions: []*types.Action{
+			{Type: "push", Container: "web", Provider: "ghcr"},
+		},
+	}
+
+	c, engine, ctxt, cleanup := newExecuteTest(t, build)
+	defer cleanup()
+
+	if err := c.Execute(ctxt); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(engine.builds) != 2 {
+		t.Fatal("Expected a build per platform, got", len(engine.builds))
+	}
+	options := engine.builds[1]
+	if !options.BuildKit || options.Platform != "linux/arm64" ||
+		!reflect.DeepEqual(options.Tags, []string{"ghcr.io/octo/web:1.2-linux-arm64"}) {
+		t.Error("Wrong build options:", options)
+	}
+
+	expectedPushes := []string{
+		"ghcr.io/octo/web:1.2 ghcr-creds",
+		"ghcr.io/octo/web:1.2 ghcr-creds",
+		"ghcr.io/octo/web:1.2 ghcr.io/octo/web@sha256:digest-1,ghcr.io/octo/web@sha256:digest-2 ghcr-creds",
+	}
+	if !reflect.DeepEqual(engine.pushes, expectedPushes) {
+		t.Error("Wrong pushes:", engine.pushes)
+	}
+	if _, ok := engine.images["ghcr.io/octo/web:1.2"]; ok {
+		t.Error("Push tag not removed")
+	}
+
+	images := ctxt.Record.Images
+	if len(images) != 3 || images[0].Image != "ghcr.io/octo/web@sha256:digest-1" ||
+		images[2].Image != "ghcr.io/octo/web:1.2" || images[2].Digest != "sha256:list-2" {
+		t.Error("Wrong images:", images)
+	}
+}
+
+func TestExecutePlatformsWithoutDigest(t *testing.T) {
+	build := &types.Build{
+		Containers: []*types.Container{
+			{Name: "web", Tag: "ghcr.io/octo/web:1.2", Platforms: []string{"linux/amd64"}},
+		},
+		Actions: []*types.Action{
+			{Type: "push", Container: "web", Provider: "ghcr"},
+		},
+	}
+
+	c, engine, ctxt, cleanup := newExecuteTest(t, build)
+	defer cleanup()
+	engine.noDigest = true
+
+	err := c.Execute(ctxt)
+	if err == nil || err.Error() != "No digest for the linux/amd64 image of ghcr.io/octo/web:1.2" {
+		t.Error("Wrong error:", err)
+	}
+	if len(engine.pushes) != 1 {
+		t.Error("Expected no manifest list push:", engine.pushes)
+	}
+}
+
+func TestExecuteArtifacts(t *testing.T) {
+	build := &types.Build{
+		Containers: []*types.Container{
+			{Tag: "app:latest", Artifacts: []string{"/usr/local/bin/app"}},
+		},
+		Actions: []*types.Action{
+			{Type: "exec", Image: "app:latest", Commands: []string{"make test"}, Artifacts: []string{"/src/report.xml"}},
+		},
+	}
+
+	c, _, ctxt, cleanup := newExecuteTest(t, build)
+	defer cleanup()
+
+	if err := c.Execute(ctxt); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*types.ArtifactRecord{
+		{Path: "app", Size: int64(len("content of app:latest")), Step: "build app:latest"},
+		{Path: "report.xml", Size: int64(len("output of app:latest")), Step: "exec app:latest"},
+	}
+	if !reflect.DeepEqual(ctxt.Record.Artifacts, expected) {
+		t.Error("Wrong artifacts:", ctxt.Record.Artifacts)
+	}
+
+	file, err := c.artifacts.Open(ctxt.ID, "report.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if content, _ := ioutil.ReadAll(file); string(content) != "output of app:latest" {
+		t.Error("Wrong artifact content:", string(content))
+	}
+}
+
+// fakeStorageProvider records the uploaded files and their content
+type fakeStorageProvider struct {
+	files    []*types.UploadFile
+	contents []string
+}
+
+func (p *fakeStorageProvider) Upload(ctx context.Context, file *types.UploadFile, body io.Reader) (string, error) {
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	p.files = append(p.files, file)
+	p.contents = append(p.contents, string(content))
+	return "https://" + file.Bucket + ".s3.amazonaws.com/" + file.Key, nil
+}
+
+func TestExecuteUpload(t *testing.T) {
+	build := &types.Build{
+		Containers: []*types.Container{
+			{Tag: "app:latest", Artifacts: []string{"/reports/junit.xml", "/usr/bin/app"}},
+		},
+		Actions: []*types.Action{
+			{
+				Type:      "upload",
+				Provider:  "s3",
+				Bucket:    "builds",
+				Files:     []string{"dist", "*.html"},
+				Artifacts: []string{"*.xml"},
+				Public:    true,
+			},
+			{Type: "upload", Provider: "s3", Bucket: "builds", Key: "{build}/{name}", Files: []string{"dist/*.json"}, ContentType: "application/vnd.cheops+json"},
+		},
+	}
+
+	c, _, ctxt, cleanup := newExecuteTest(t, build)
+	defer cleanup()
+	storage := &fakeStorageProvider{}
+	c.storageProviders = map[string]types.StorageProvider{"s3": storage}
+
+	os.MkdirAll(filepath.Join(ctxt.RepoDir, "dist", "bin"), 0755)
+	ioutil.WriteFile(filepath.Join(ctxt.RepoDir, "dist", "manifest.json"), []byte("{}"), 0644)
+	ioutil.WriteFile(filepath.Join(ctxt.RepoDir, "dist", "bin", "app"), []byte("binary"), 0755)
+	ioutil.WriteFile(filepath.Join(ctxt.RepoDir, "index.html"), []byte("<html>"), 0644)
+
+	if err := c.Execute(ctxt); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []types.UploadFile{
+		{Bucket: "builds", Key: "repo/main/abc/dist/bin/app", ContentType: "application/octet-stream", Public: true},
+		{Bucket: "builds", Key: "repo/main/abc/dist/manifest.json", ContentType: "application/json", Public: true},
+		{Bucket: "builds", Key: "repo/main/abc/index.html", ContentType: "text/html; charset=utf-8", Public: true},
+		{Bucket: "builds", Key: "repo/main/abc/junit.xml", ContentType: "text/xml; charset=utf-8", Public: true},
+		{Bucket: "builds", Key: "1234/manifest.json", ContentType: "application/vnd.cheops+json"},
+	}
+	if len(storage.files) != len(expected) {
+		t.Fatal("Wrong uploads:", storage.files)
+	}
+	for i, file := range storage.files {
+		if *file != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], *file)
+		}
+	}
+	if storage.contents[0] != "binary" || storage.contents[3] != "content of app:latest" {
+		t.Error("Wrong contents:", storage.contents)
+	}
+
+	err := c.procAction(context.Background(), ctxt, &types.Action{Type: "upload", Provider: "s3", Files: []string{"missing/*"}}, ioutil.Discard)
+	if err == nil || err.Error() != "No files to upload" {
+		t.Error("Wrong error:", err)
+	}
+}
+
+func TestWorkspaceFilesSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cheops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repoDir := filepath.Join(dir, "repo")
+	serverDir := filepath.Join(dir, "server")
+	os.MkdirAll(filepath.Join(repoDir, "dist"), 0755)
+	os.MkdirAll(serverDir, 0755)
+	ioutil.WriteFile(filepath.Join(repoDir, "dist", "app"), []byte("binary"), 0644)
+	ioutil.WriteFile(filepath.Join(serverDir, "cheops.yaml"), []byte("api_token: secret"), 0600)
+	os.Symlink(serverDir, filepath.Join(repoDir, "cfg"))
+	os.Symlink(filepath.Join(repoDir, "dist"), filepath.Join(repoDir, "out"))
+
+	for _, pattern := range []string{"cfg/cheops.yaml", "cfg/*", "*/cheops.yaml"} {
+		if _, err := workspaceFiles(repoDir, []string{pattern}); err == nil ||
+			!strings.HasPrefix(err.Error(), "File outside of the workspace") {
+			t.Error("Expected an error for", pattern, "got", err)
+		}
+	}
+
+	sources, err := workspaceFiles(repoDir, []string{"out/app"})
+	if err != nil || len(sources) != 1 || sources[0].path != "out/app" {
+		t.Error("Wrong files linked in the workspace:", sources, err)
+	}
+}
+
+func TestRepoName(t *testing.T) {
+	tests := map[string]string{
+		"https://github.com/octo/app.git":  "octo/app",
+		"https://gitlab.com/group/sub/app": "group/sub/app",
+		"git@github.com:octo/app.git":      "octo/app",
+		"ssh://git@example.com/octo/app/":  "octo/app",
+	}
+
+	for url, expected := range tests {
+		if name := repoName(url); name != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, url, name)
+		}
+	}
+}
+
+func TestExecuteFailingCommands(t *testing.T) {
+	build := &types.Build{
+		Actions: []*types.Action{
+			{Type: "exec", Image: "alpine", Commands: []string{"make test"}, Artifacts: []string{"/src/report.xml"}},
+			{Type: "exec", Image: "alpine", Commands: []string{"make deploy"}},
+		},
+	}
+
+	c, engine, ctxt, cleanup := newExecuteTest(t, build)
+	defer cleanup()
+	engine.runStatus = 2
+
+	err := c.Execute(ctxt)
+	if err == nil || err.Error() != "Commands exited with status 2" {
+		t.Fatal("Wrong error:", err)
+	}
+	if statuses := stepStatuses(ctxt.Record); !reflect.DeepEqual(statuses, []string{"exec alpine: failed"}) {
+		t.Error("Wrong steps:", statuses)
+	}
+	if len(engine.runs) != 1 {
+		t.Error("Expected a single container run, got", len(engine.runs))
+	}
+	if len(ctxt.Record.Artifacts) != 0 {
+		t.Error("Artifacts exported from failed commands:", ctxt.Record.Artifacts)
+	}
+}
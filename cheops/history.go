@@ -0,0 +1,197 @@
+package cheops
+
+import (
+	"cheops/types"
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const pruneInterval = time.Hour
+
+var errBuildCancelled = errors.New("Build cancelled")
+
+func (c *cheopsImpl) saveRecord(record *types.BuildRecord) {
+	if c.store == nil || record == nil {
+		return
+	}
+
+	if err := c.store.SaveBuild(record); err != nil {
+		log.WithFields(log.Fields{
+			"build": record.ID,
+			"error": err,
+		}).Warn("Can't save build record")
+	}
+}
+
+func (c *cheopsImpl) saveDelivery(delivery *types.Delivery) {
+	if c.store == nil {
+		return
+	}
+
+	if err := c.store.SaveDelivery(delivery); err != nil {
+		log.WithFields(log.Fields{
+			"delivery": delivery.ID,
+			"error":    err,
+		}).Warn("Can't save webhook delivery")
+	}
+}
+
+func newBuildRecord(id string, commit *types.CommitInfo, params map[string]string) *types.BuildRecord {
+	return &types.BuildRecord{
+		ID:        id,
+		RepoURL:   commit.RepoURL,
+		Branch:    commit.Branch,
+		Commit:    commit,
+		Params:    params,
+		Status:    types.StatusPending,
+		Steps:     []*types.StepRecord{},
+		CreatedAt: time.Now(),
+	}
+}
+
+// startStep records a new running step in the build, record may be nil
+func (c *cheopsImpl) startStep(record *types.BuildRecord, name string) *types.StepRecord {
+	step := &types.StepRecord{
+		Name:      name,
+		Status:    types.StatusRunning,
+		StartedAt: time.Now(),
+	}
+
+	if record != nil {
+		record.Steps = append(record.Steps, step)
+		c.saveRecord(record)
+	}
+
+	return step
+}
+
+func (c *cheopsImpl) finishStep(record *types.BuildRecord, step *types.StepRecord, err error) {
+	step.FinishedAt = time.Now()
+	if err != nil {
+		step.Status = types.StatusFailed
+		step.Error = err.Error()
+	} else {
+		step.Status = types.StatusSuccess
+	}
+
+	c.saveRecord(record)
+}
+
+// recordImage adds a pushed image to the build, record may be nil
+func (c *cheopsImpl) recordImage(record *types.BuildRecord, image, digest string) {
+	if record == nil {
+		return
+	}
+
+	record.Images = append(record.Images, &types.ImageRecord{
+		Image:  image,
+		Digest: digest,
+	})
+	c.saveRecord(record)
+}
+
+func (c *cheopsImpl) finishBuild(record *types.BuildRecord, err error) {
+	record.FinishedAt = time.Now()
+	switch err {
+	case nil:
+		record.Status = types.StatusSuccess
+	case errBuildCancelled:
+		record.Status = types.StatusCancelled
+		record.Error = err.Error()
+	default:
+		record.Status = types.StatusFailed
+		record.Error = err.Error()
+	}
+
+	log.WithFields(log.Fields{
+		"build":    record.ID,
+		"status":   record.Status,
+		"duration": record.FinishedAt.Sub(record.StartedAt),
+	}).Info("Build finished")
+
+	c.saveRecord(record)
+}
+
+// trackBuild returns the context of a new running build
+func (c *cheopsImpl) trackBuild(id string) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c.runningMutex.Lock()
+	c.running[id] = cancel
+	c.runningMutex.Unlock()
+
+	return ctx
+}
+
+func (c *cheopsImpl) untrackBuild(id string) {
+	c.runningMutex.Lock()
+	defer c.runningMutex.Unlock()
+
+	if cancel, ok := c.running[id]; ok {
+		cancel()
+		delete(c.running, id)
+	}
+}
+
+// cancelBuild cancels a running build, it returns false if the build isn't
+// running
+func (c *cheopsImpl) cancelBuild(id string) bool {
+	c.runningMutex.Lock()
+	defer c.runningMutex.Unlock()
+
+	cancel, ok := c.running[id]
+	if ok {
+		log.WithFields(log.Fields{
+			"build": id,
+		}).Info("Cancelling build")
+		cancel()
+	}
+	return ok
+}
+
+func (c *cheopsImpl) pruneHistory() {
+	policy := &c.Config().General.History
+	if policy.MaxBuilds == 0 && policy.MaxAge == 0 {
+		return
+	}
+
+	pruned, err := c.store.Prune(policy)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warn("Can't prune build history")
+		return
+	}
+
+	for _, id := range pruned {
+		if err := c.logs.Remove(id); err != nil {
+			log.WithFields(log.Fields{
+				"build": id,
+				"error": err,
+			}).Warn("Can't remove build log")
+		}
+		if c.artifacts == nil {
+			continue
+		}
+		if err := c.artifacts.Remove(id); err != nil {
+			log.WithFields(log.Fields{
+				"build": id,
+				"error": err,
+			}).Warn("Can't remove build artifacts")
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"builds": len(pruned),
+	}).Debug("Pruned build history")
+}
+
+func (c *cheopsImpl) pruneHistoryLoop() {
+	for {
+		c.pruneHistory()
+		time.Sleep(pruneInterval)
+	}
+}
@@ -0,0 +1,71 @@
+package cheops
+
+import (
+	"bufio"
+	"cheops/buildlog"
+	"cheops/types"
+	"io"
+	"io/ioutil"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// createLog starts capturing the output of a build. Builds still run when
+// the log can't be created, the nil log returned discards their output.
+func (c *cheopsImpl) createLog(id string) *buildlog.Log {
+	if c.logs == nil {
+		return nil
+	}
+
+	buildLog, err := c.logs.Create(id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"build": id,
+			"error": err,
+		}).Warn("Can't create build log")
+		return nil
+	}
+
+	return buildLog
+}
+
+func (c *cheopsImpl) closeLog(buildLog *buildlog.Log, id string) {
+	if err := buildLog.Close(); err != nil {
+		log.WithFields(log.Fields{
+			"build": id,
+			"error": err,
+		}).Warn("Can't close build log")
+	}
+}
+
+// logTail returns the last lines of the log of a build
+func (c *cheopsImpl) logTail(id string, lines int) []string {
+	if c.logs == nil {
+		return nil
+	}
+
+	reader, err := c.logs.Open(id)
+	if err != nil {
+		return nil
+	}
+	defer reader.Close()
+
+	tail := []string{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		tail = append(tail, scanner.Text())
+		if len(tail) > lines {
+			tail = tail[1:]
+		}
+	}
+
+	return tail
+}
+
+// stepLog returns the writer for the output of a step, buildLog may be nil
+func stepLog(buildLog types.BuildLog, step string) io.Writer {
+	if buildLog == nil {
+		return ioutil.Discard
+	}
+	return buildLog.Step(step)
+}
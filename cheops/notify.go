@@ -0,0 +1,63 @@
+package cheops
+
+import (
+	"cheops/notify"
+	"cheops/types"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// logTailLines is the amount of log lines sent along failure notifications
+const logTailLines = 30
+
+// buildURL returns the link to a build in the dashboard
+func (c *cheopsImpl) buildURL(id string) string {
+	base := c.Config().General.WebhookURL
+	if base == "" {
+		return ""
+	}
+	return strings.TrimSuffix(base, "/") + "/ui/#build/" + id
+}
+
+// notify sends the event to every notifier of the build that wants it
+func (c *cheopsImpl) notify(build *types.Build, record *types.BuildRecord, event string) {
+	buildEvent := &types.BuildEvent{
+		Event: event,
+		Build: record,
+		URL:   c.buildURL(record.ID),
+	}
+	if event == types.EventFailure {
+		buildEvent.LogTail = c.logTail(record.ID, logTailLines)
+	}
+
+	for _, config := range build.Notifiers {
+		if !notify.Wants(config, event) {
+			continue
+		}
+
+		notifier, err := notify.New(config, c.Config().Providers.SMTP)
+		if err == nil {
+			err = notifier.Notify(buildEvent)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"build":    record.ID,
+				"notifier": config.Type,
+				"event":    event,
+				"error":    err,
+			}).Warn("Can't send notification")
+		}
+	}
+}
+
+func finishEvent(record *types.BuildRecord) string {
+	switch record.Status {
+	case types.StatusSuccess:
+		return types.EventSuccess
+	case types.StatusCancelled:
+		return types.EventCancelled
+	default:
+		return types.EventFailure
+	}
+}
@@ -0,0 +1,84 @@
+package cheops
+
+import (
+	"cheops/docker"
+	"cheops/types"
+	"context"
+	"errors"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// platformTags returns the tags of a platform of multi-platform images
+func platformTags(tags []string, platform string) []string {
+	platformTags := []string{}
+	for _, tag := range tags {
+		platformTags = append(platformTags, docker.PlatformTag(tag, platform))
+	}
+	return platformTags
+}
+
+// buildImages builds the image of a container, or with BuildKit one image
+// per platform of multi-platform containers
+func (c *cheopsImpl) buildImages(ctx context.Context, options *types.BuildOptions, platforms []string, out io.Writer) error {
+	if len(platforms) == 0 {
+		imageID, err := c.engine.BuildImage(ctx, options, out)
+		if err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"tags":  options.Tags,
+			"image": imageID,
+		}).Debug("Built image")
+		return nil
+	}
+
+	for _, platform := range platforms {
+		platformOptions := *options
+		platformOptions.BuildKit = true
+		platformOptions.Platform = platform
+		platformOptions.Tags = platformTags(options.Tags, platform)
+
+		imageID, err := c.engine.BuildImage(ctx, &platformOptions, out)
+		if err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"tags":     platformOptions.Tags,
+			"platform": platform,
+			"image":    imageID,
+		}).Debug("Built image")
+	}
+	return nil
+}
+
+// pushPlatforms pushes the platform images of a multi-platform target, then
+// the manifest list referencing them by digest, and returns the digest of the
+// list. The platform images are pushed under the tag of the target, which
+// the list replaces, so that no platform tags are left in the registry.
+func (c *cheopsImpl) pushPlatforms(ctx context.Context, record *types.BuildRecord, target *pushTarget, creds string, out io.Writer) (string, error) {
+	manifests := []string{}
+	for _, platform := range target.platforms {
+		platformTarget := &pushTarget{
+			source: docker.PlatformTag(target.source, platform),
+			image:  target.image,
+		}
+
+		digest, err := c.pushImage(ctx, platformTarget, creds, out)
+		if err != nil {
+			return "", err
+		}
+		if digest == "" {
+			return "", errors.New("No digest for the " + platform + " image of " + target.image)
+		}
+
+		manifest := docker.DigestReference(target.image, digest)
+		c.recordImage(record, manifest, digest)
+		manifests = append(manifests, manifest)
+	}
+
+	return c.engine.PushManifestList(ctx, target.image, manifests, creds, out)
+}
@@ -0,0 +1,153 @@
+package cheops
+
+import (
+	"cheops/docker"
+	"cheops/types"
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pushTarget is an image to push, tagged from source first if they differ
+type pushTarget struct {
+	source   string
+	image    string
+	provider string
+	// platforms are those of multi-platform images
+	platforms []string
+}
+
+// containerTags returns all the tags of a container, Tag first
+func containerTags(container *types.Container) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, tag := range append([]string{container.Tag}, container.Tags...) {
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// findContainer returns the container of the build with the name, or the
+// tag for containers without a name
+func findContainer(build *types.Build, name string) (*types.Container, error) {
+	for _, container := range build.Containers {
+		if container.Name == name || (container.Name == "" && container.Tag == name) {
+			return container, nil
+		}
+	}
+	return nil, errors.New("Unknown container: " + name)
+}
+
+// pushTargets returns the images a push action pushes
+func pushTargets(build *types.Build, action *types.Action) ([]*pushTarget, error) {
+	if action.Container == "" {
+		return []*pushTarget{{action.Image, action.Image, action.Provider, nil}}, nil
+	}
+
+	container, err := findContainer(build, action.Container)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := containerTags(container)
+	if len(action.Registries) == 0 {
+		targets := []*pushTarget{}
+		for _, tag := range tags {
+			targets = append(targets, &pushTarget{tag, tag, action.Provider, container.Platforms})
+		}
+		return targets, nil
+	}
+
+	targets := []*pushTarget{}
+	for _, registry := range action.Registries {
+		provider := registry.Provider
+		if provider == "" {
+			provider = action.Provider
+		}
+
+		repository := strings.TrimSuffix(registry.Repository, "/")
+		for _, tag := range tags {
+			image := repository + ":" + docker.ImageTag(tag)
+			targets = append(targets, &pushTarget{tag, image, provider, container.Platforms})
+		}
+	}
+	return targets, nil
+}
+
+// pushImages runs a push action and records the pushed images
+func (c *cheopsImpl) pushImages(ctx context.Context, ctxt *types.BuildContext, action *types.Action, out io.Writer) error {
+	targets, err := pushTargets(ctxt.Build, action)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		if ctx.Err() != nil {
+			return errBuildCancelled
+		}
+
+		provider, ok := c.dockerCredsProviders[target.provider]
+		if !ok {
+			return errors.New("Unknown provider: " + target.provider)
+		}
+
+		host := docker.RegistryHost(target.image)
+		log.WithFields(log.Fields{
+			"provider": target.provider,
+			"registry": host,
+		}).Debug("Getting Docker credentials")
+		creds, err := provider.GetCredentials(host)
+		if err != nil {
+			return err
+		}
+
+		if creator, ok := provider.(types.RepositoryCreator); ok {
+			err = creator.EnsureRepository(target.image)
+			if err != nil {
+				return err
+			}
+		}
+
+		var digest string
+		if len(target.platforms) == 0 {
+			digest, err = c.pushImage(ctx, target, creds, out)
+		} else {
+			digest, err = c.pushPlatforms(ctx, ctxt.Record, target, creds, out)
+		}
+		if err != nil {
+			return err
+		}
+		c.recordImage(ctxt.Record, target.image, digest)
+	}
+
+	return nil
+}
+
+// pushImage pushes a target, tagging it first and removing the tag after if
+// it's only used to push to another registry
+func (c *cheopsImpl) pushImage(ctx context.Context, target *pushTarget, creds string, out io.Writer) (string, error) {
+	if target.source != target.image {
+		err := c.engine.TagImage(ctx, target.source, target.image)
+		if err != nil {
+			return "", err
+		}
+
+		defer func() {
+			err := c.engine.RemoveImage(context.Background(), target.image)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"image": target.image,
+					"error": err,
+				}).Warn("Can't remove push tag")
+			}
+		}()
+	}
+
+	return c.engine.PushImage(ctx, target.image, creds, out)
+}
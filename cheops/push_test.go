@@ -0,0 +1,60 @@
+package cheops
+
+import (
+	"cheops/types"
+	"reflect"
+	"testing"
+)
+
+func TestPushTargets(t *testing.T) {
+	build := &types.Build{
+		Containers: []*types.Container{
+			{Tag: "app:latest"},
+			{Name: "web", Tag: "ghcr.io/octo/web:1.2", Tags: []string{"ghcr.io/octo/web:latest", "ghcr.io/octo/web:1.2"}},
+		},
+	}
+
+	tests := []struct {
+		action   *types.Action
+		expected []*pushTarget
+	}{
+		{
+			&types.Action{Image: "app:latest", Provider: "hub"},
+			[]*pushTarget{{"app:latest", "app:latest", "hub", nil}},
+		},
+		{
+			&types.Action{Container: "web", Provider: "ghcr"},
+			[]*pushTarget{
+				{"ghcr.io/octo/web:1.2", "ghcr.io/octo/web:1.2", "ghcr", nil},
+				{"ghcr.io/octo/web:latest", "ghcr.io/octo/web:latest", "ghcr", nil},
+			},
+		},
+		{
+			&types.Action{Container: "app:latest", Provider: "ecr", Registries: []*types.PushRegistry{
+				{Repository: "123.dkr.ecr.eu-west-1.amazonaws.com/app"},
+				{Repository: "ghcr.io/octo/app/", Provider: "ghcr"},
+			}},
+			[]*pushTarget{
+				{"app:latest", "123.dkr.ecr.eu-west-1.amazonaws.com/app:latest", "ecr", nil},
+				{"app:latest", "ghcr.io/octo/app:latest", "ghcr", nil},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		targets, err := pushTargets(build, test.action)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(targets, test.expected) {
+			t.Errorf("Wrong targets for %+v:", test.action)
+			for _, target := range targets {
+				t.Log(target)
+			}
+		}
+	}
+
+	if _, err := pushTargets(build, &types.Action{Container: "missing"}); err == nil {
+		t.Error("Expected an error for an unknown container")
+	}
+}
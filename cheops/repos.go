@@ -0,0 +1,60 @@
+package cheops
+
+import (
+	"cheops/types"
+	"errors"
+	"path"
+)
+
+var (
+	errUnknownRepo     = errors.New("Unknown repository")
+	errUnknownBranch   = errors.New("Unknown branch")
+	errUnknownEndpoint = errors.New("Unknown webhook endpoint")
+)
+
+// matchBranch reports whether branch matches pattern, which is either a
+// plain branch name or a glob pattern.
+func matchBranch(pattern, branch string) bool {
+	if pattern == branch {
+		return true
+	}
+
+	matched, err := path.Match(pattern, branch)
+	return err == nil && matched
+}
+
+func repoMatchesBranch(repo *types.Repository, branch string) bool {
+	if repo.Branch != "" && matchBranch(repo.Branch, branch) {
+		return true
+	}
+
+	for _, pattern := range repo.Branches {
+		if matchBranch(pattern, branch) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findRepo returns the first repository entry matching both the URL and the
+// branch. It returns errUnknownRepo when no entry has that URL, and
+// errUnknownBranch when some do but none of them accept the branch.
+func findRepo(repos []*types.Repository, repoURL, branch string) (*types.Repository, error) {
+	knownURL := false
+	for _, repo := range repos {
+		if repo.URL != repoURL {
+			continue
+		}
+		knownURL = true
+
+		if repoMatchesBranch(repo, branch) {
+			return repo, nil
+		}
+	}
+
+	if knownURL {
+		return nil, errUnknownBranch
+	}
+	return nil, errUnknownRepo
+}
@@ -0,0 +1,34 @@
+package cheops
+
+import (
+	"cheops/types"
+	"testing"
+)
+
+func TestFindRepo(t *testing.T) {
+	prod := &types.Repository{URL: "https://github.com/a/b.git", Branch: "main"}
+	staging := &types.Repository{URL: "https://github.com/a/b.git", Branches: []string{"develop", "feature/*"}}
+	other := &types.Repository{URL: "https://github.com/a/c.git", Branch: "main"}
+	repos := []*types.Repository{prod, staging, other}
+
+	tests := []struct {
+		url    string
+		branch string
+		repo   *types.Repository
+		err    error
+	}{
+		{"https://github.com/a/b.git", "main", prod, nil},
+		{"https://github.com/a/b.git", "develop", staging, nil},
+		{"https://github.com/a/b.git", "feature/login", staging, nil},
+		{"https://github.com/a/b.git", "hotfix", nil, errUnknownBranch},
+		{"https://github.com/a/c.git", "main", other, nil},
+		{"https://github.com/a/d.git", "main", nil, errUnknownRepo},
+	}
+
+	for _, test := range tests {
+		repo, err := findRepo(repos, test.url, test.branch)
+		if repo != test.repo || err != test.err {
+			t.Errorf("findRepo(%s, %s) = %v, %v", test.url, test.branch, repo, err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package cheops
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// rerunBuild starts a new build of the same commit and parameters as a past
+// build
+func (c *cheopsImpl) rerunBuild(id string) (string, error) {
+	original, err := c.store.GetBuild(id)
+	if err != nil {
+		return "", err
+	}
+
+	repo, err := findRepo(c.Config().Repos, original.RepoURL, original.Branch)
+	if err != nil {
+		return "", err
+	}
+
+	log.WithFields(log.Fields{
+		"build": id,
+	}).Info("Re-running build")
+
+	record := newBuildRecord(newBuildID(), original.Commit, original.Params)
+	record.RerunOf = original.ID
+	record.DeliveryID = original.DeliveryID
+	return c.startBuild(repo, record), nil
+}
+
+// replayDelivery processes a stored webhook delivery again, as if it had
+// just been received, skip directives included
+func (c *cheopsImpl) replayDelivery(id string) (string, error) {
+	delivery, err := c.store.GetDelivery(id)
+	if err != nil {
+		return "", err
+	}
+
+	repo, commit, err := c.parseDelivery(delivery)
+	if err != nil {
+		return "", err
+	}
+
+	log.WithFields(log.Fields{
+		"delivery": id,
+	}).Info("Replaying webhook delivery")
+
+	record := newBuildRecord(newBuildID(), commit, nil)
+	record.DeliveryID = delivery.ID
+	record.RerunOf = delivery.BuildID
+	return c.startDeliveryBuild(repo, record), nil
+}
@@ -1,14 +1,19 @@
 package cheops
 
 import (
+	"bytes"
 	"cheops/types"
+	"io/ioutil"
 	"net/http"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
 func (c *cheopsImpl) Serve() error {
 	general := c.Config().General
+	c.registerDashboard()
+
 	log.WithFields(log.Fields{
 		"bindAddr": general.BindAddr,
 	}).Info("Webhook Server listening")
@@ -16,58 +21,90 @@ func (c *cheopsImpl) Serve() error {
 	return http.ListenAndServeTLS(general.BindAddr, general.TLSCert, general.TLSKey, nil)
 }
 
+// parseDelivery runs a webhook delivery through the webhook function of its
+// endpoint and returns the repository and commit to build
+func (c *cheopsImpl) parseDelivery(delivery *types.Delivery) (*types.Repository, *types.CommitInfo, error) {
+	webhook, ok := c.webhooks[delivery.Endpoint]
+	if !ok {
+		return nil, nil, errUnknownEndpoint
+	}
+
+	body := ioutil.NopCloser(bytes.NewReader(delivery.Payload))
+	commit, err := webhook(body, delivery.Headers)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":    err,
+			"endpoint": delivery.Endpoint,
+		}).Warn("Webhook processing failed")
+		return nil, nil, err
+	}
+
+	repo, err := findRepo(c.Config().Repos, commit.RepoURL, commit.Branch)
+	switch err {
+	case nil:
+	case errUnknownRepo:
+		log.WithFields(log.Fields{
+			"endpoint":   delivery.Endpoint,
+			"repository": commit.RepoURL,
+			"branch":     commit.Branch,
+		}).Warn("Not building unknown repo")
+
+	default:
+		log.WithFields(log.Fields{
+			"endpoint": delivery.Endpoint,
+			"branch":   commit.Branch,
+		}).Debug("Not building unknown branch")
+	}
+
+	return repo, commit, err
+}
+
+// processDelivery starts the build for a newly received webhook delivery, or
+// records it as skipped, and saves the delivery
+func (c *cheopsImpl) processDelivery(delivery *types.Delivery) {
+	defer c.saveDelivery(delivery)
+
+	repo, commit, err := c.parseDelivery(delivery)
+	delivery.Commit = commit
+	if err != nil {
+		delivery.Error = err.Error()
+		return
+	}
+
+	record := newBuildRecord(newBuildID(), commit, nil)
+	record.DeliveryID = delivery.ID
+	delivery.BuildID = c.startDeliveryBuild(repo, record)
+}
+
 func (c *cheopsImpl) RegisterWebhook(endpoint string, webhook types.WebhookFunc) {
 	log.WithFields(log.Fields{
 		"endpoint": endpoint,
 	}).Debug("Registering webhook")
 
+	c.webhooks[endpoint] = webhook
+
 	http.HandleFunc(endpoint, func(w http.ResponseWriter, r *http.Request) {
 		log.WithFields(log.Fields{
 			"endpoint": endpoint,
 		}).Debug("Received webhook")
 
 		w.WriteHeader(200)
-		commit, err := webhook(r.Body, r.Header)
+		payload, err := ioutil.ReadAll(r.Body)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"error":    err,
 				"endpoint": endpoint,
-			}).Warn("Webhook processing failed")
-
+			}).Warn("Can't read webhook")
 			return
 		}
 
-		var repo *types.Repository
-		for _, repo = range c.Config().Repos {
-			if repo.URL == commit.RepoURL {
-				break
-			}
+		delivery := &types.Delivery{
+			ID:         newBuildID(),
+			Endpoint:   endpoint,
+			Headers:    r.Header,
+			Payload:    payload,
+			ReceivedAt: time.Now(),
 		}
-
-		if repo == nil {
-			log.WithFields(log.Fields{
-				"endpoint":   endpoint,
-				"repository": commit.RepoURL,
-				"branch":     commit.Branch,
-			}).Warn("Not building unknown repo")
-			return
-		}
-
-		if repo.Branch != commit.Branch {
-			log.WithFields(log.Fields{
-				"endpoint": endpoint,
-				"branch":   commit.Branch,
-			}).Debug("Not building unknown branch")
-
-			return
-		}
-
-		go func() {
-			ctxt, err := c.GetBuildContext(repo, commit)
-			if err != nil {
-				return
-			}
-			c.Execute(ctxt)
-		}()
+		c.processDelivery(delivery)
 	})
 }
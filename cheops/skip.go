@@ -0,0 +1,53 @@
+package cheops
+
+import (
+	"cheops/types"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var defaultSkipDirectives = []string{"[skip ci]", "[ci skip]"}
+
+// skipDirective returns the directive of the repository found in the head
+// commit message, or an empty string if the commit should be built
+func skipDirective(repo *types.Repository, commit *types.CommitInfo) string {
+	directives := repo.SkipDirectives
+	if directives == nil {
+		directives = defaultSkipDirectives
+	}
+
+	message := strings.ToLower(commit.Message)
+	for _, directive := range directives {
+		if directive != "" && strings.Contains(message, strings.ToLower(directive)) {
+			return directive
+		}
+	}
+
+	return ""
+}
+
+// startDeliveryBuild starts the build of a webhook delivery, or skips it
+// when the commit message has a skip directive, and returns its ID
+func (c *cheopsImpl) startDeliveryBuild(repo *types.Repository, record *types.BuildRecord) string {
+	if directive := skipDirective(repo, record.Commit); directive != "" {
+		c.skipBuild(record, directive)
+		return record.ID
+	}
+	return c.startBuild(repo, record)
+}
+
+// skipBuild records the build as skipped without running it
+func (c *cheopsImpl) skipBuild(record *types.BuildRecord, directive string) {
+	log.WithFields(log.Fields{
+		"build":     record.ID,
+		"commit":    record.Commit.ID,
+		"directive": directive,
+	}).Info("Skipping build")
+
+	record.Status = types.StatusSkipped
+	record.SkippedBy = directive
+	record.FinishedAt = time.Now()
+	c.saveRecord(record)
+}
@@ -0,0 +1,86 @@
+package cheops
+
+import (
+	"cheops/types"
+	"testing"
+	"time"
+)
+
+func TestSkipDirective(t *testing.T) {
+	repo := &types.Repository{}
+	custom := &types.Repository{SkipDirectives: []string{"[no build]"}}
+	disabled := &types.Repository{SkipDirectives: []string{}}
+
+	tests := []struct {
+		repo     *types.Repository
+		message  string
+		expected string
+	}{
+		{repo, "Fix typo", ""},
+		{repo, "Update docs [skip ci]", "[skip ci]"},
+		{repo, "Bump version\n\n[CI SKIP]", "[ci skip]"},
+		{custom, "Update docs [skip ci]", ""},
+		{custom, "Update docs [no build]", "[no build]"},
+		{disabled, "Update docs [skip ci]", ""},
+	}
+
+	for _, test := range tests {
+		directive := skipDirective(test.repo, &types.CommitInfo{Message: test.message})
+		if directive != test.expected {
+			t.Errorf("Expected %q for %q, got %q", test.expected, test.message, directive)
+		}
+	}
+}
+
+func TestSkipDelivery(t *testing.T) {
+	c, cleanup := newTestCheops(t)
+	defer cleanup()
+
+	c.processDelivery(&types.Delivery{
+		ID:         "1",
+		Endpoint:   "/fake",
+		Payload:    []byte(`{"id":"abc","branch":"main","repo_url":"https://example.com/repo.git","message":"Bump version [skip ci]"}`),
+		ReceivedAt: time.Now(),
+	})
+
+	delivery, err := c.store.GetDelivery("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := c.store.GetBuild(delivery.BuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != types.StatusSkipped || record.SkippedBy != "[skip ci]" || len(record.Steps) != 0 {
+		t.Error("Wrong skipped build:", record)
+	}
+	if _, ok := c.running[record.ID]; ok {
+		t.Error("Skipped build is running")
+	}
+}
+
+func TestSkipReplayedDelivery(t *testing.T) {
+	c, cleanup := newTestCheops(t)
+	defer cleanup()
+
+	c.saveDelivery(&types.Delivery{
+		ID:       "1",
+		Endpoint: "/fake",
+		Payload:  []byte(`{"id":"abc","branch":"main","repo_url":"https://example.com/repo.git","message":"Bump version [skip ci]"}`),
+	})
+
+	id, err := c.replayDelivery("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := c.store.GetBuild(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != types.StatusSkipped || record.SkippedBy != "[skip ci]" || record.DeliveryID != "1" {
+		t.Error("Wrong skipped build:", record)
+	}
+	if _, ok := c.running[record.ID]; ok {
+		t.Error("Skipped build is running")
+	}
+}
@@ -0,0 +1,90 @@
+package cheops
+
+import (
+	"cheops/store"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// buildTokenLifetime is how long build tokens are accepted. They are only
+// checked when a download or log stream starts, so a stream outlives them.
+const buildTokenLifetime = 5 * time.Minute
+
+type buildTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// buildTokenMAC signs the build ID and expiry time of a build token with the
+// API token, so that changing the API token revokes the build tokens
+func (c *cheopsImpl) buildTokenMAC(id string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(c.Config().General.APIToken))
+	mac.Write([]byte(id + "." + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// buildToken returns a token giving access to the log stream and artifacts
+// of a build until expires. Unlike the API token, it can be passed in the
+// query, which is the only way for EventSource and download links.
+func (c *cheopsImpl) buildToken(id string, expires time.Time) string {
+	seconds := expires.Unix()
+	return strconv.FormatInt(seconds, 10) + "." + c.buildTokenMAC(id, seconds)
+}
+
+// validBuildToken checks that token is a build token of the build that
+// hasn't expired
+func (c *cheopsImpl) validBuildToken(id, token string) bool {
+	if c.Config().General.APIToken == "" {
+		return false
+	}
+
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return false
+	}
+
+	return hmac.Equal([]byte(parts[1]), []byte(c.buildTokenMAC(id, expires)))
+}
+
+// buildTokenAuthorized checks that a request reads the log stream or an
+// artifact of a build, with a build token of that build in the query
+func (c *cheopsImpl) buildTokenAuthorized(r *http.Request) bool {
+	if r.Method != http.MethodGet || !strings.HasPrefix(r.URL.Path, "/api/builds/") {
+		return false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/builds/"), "/", 2)
+	if len(parts) != 2 || (parts[1] != "log/stream" && !strings.HasPrefix(parts[1], "artifacts/")) {
+		return false
+	}
+
+	return c.validBuildToken(parts[0], r.URL.Query().Get("token"))
+}
+
+// handleBuildToken issues a build token, for clients that can't set the
+// Authorization header
+func (c *cheopsImpl) handleBuildToken(w http.ResponseWriter, id string) {
+	_, err := c.store.GetBuild(id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	expires := time.Now().Add(buildTokenLifetime).Truncate(time.Second)
+	writeJSON(w, http.StatusOK, &buildTokenResponse{
+		Token:     c.buildToken(id, expires),
+		ExpiresAt: expires,
+	})
+}
@@ -0,0 +1,201 @@
+package cheops
+
+import (
+	"cheops/types"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultUploadKey is the key of uploaded files without a Key
+const defaultUploadKey = "{repo}/{branch}/{commit}/{path}"
+
+// uploadSource is a file an upload action uploads, path is slash separated
+type uploadSource struct {
+	path string
+	open func() (io.ReadCloser, error)
+}
+
+// repoName returns the path of a repository URL, e.g. octo/app for
+// https://github.com/octo/app.git or git@github.com:octo/app.git
+func repoName(url string) string {
+	name := url
+	if i := strings.Index(name, "://"); i != -1 {
+		name = name[i+3:]
+		name = name[strings.IndexRune(name, '/')+1:]
+	} else if i := strings.IndexRune(name, ':'); i != -1 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(strings.Trim(name, "/"), ".git")
+}
+
+// uploadKey returns the key of an uploaded file
+func uploadKey(key string, ctxt *types.BuildContext, filePath string) string {
+	if key == "" {
+		key = defaultUploadKey
+	}
+
+	return strings.NewReplacer(
+		"{repo}", repoName(ctxt.Commit.RepoURL),
+		"{branch}", ctxt.Commit.Branch,
+		"{commit}", ctxt.Commit.ID,
+		"{build}", ctxt.ID,
+		"{path}", filePath,
+		"{name}", path.Base(filePath),
+	).Replace(key)
+}
+
+// uploadContentType returns the content type of an uploaded file
+func uploadContentType(action *types.Action, filePath string) string {
+	if action.ContentType != "" {
+		return action.ContentType
+	}
+	if contentType := mime.TypeByExtension(path.Ext(filePath)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// workspaceFiles returns the regular files matching the patterns in the
+// workspace, walking the matching directories. Files reached through
+// symbolic links must be in the workspace too, so that a repository can't
+// upload files of the server.
+func workspaceFiles(repoDir string, patterns []string) ([]*uploadSource, error) {
+	resolvedDir, err := filepath.EvalSymlinks(repoDir)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []*uploadSource{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(repoDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, match := range matches {
+			err := filepath.Walk(match, func(file string, info os.FileInfo, err error) error {
+				if err != nil || !info.Mode().IsRegular() {
+					return err
+				}
+
+				relPath, err := filepath.Rel(repoDir, file)
+				if err != nil {
+					return err
+				}
+				if strings.HasPrefix(relPath, "..") {
+					return errors.New("File outside of the workspace: " + relPath)
+				}
+
+				resolved, err := filepath.EvalSymlinks(file)
+				if err != nil {
+					return err
+				}
+				resolvedRel, err := filepath.Rel(resolvedDir, resolved)
+				if err != nil {
+					return err
+				}
+				if resolvedRel == ".." || strings.HasPrefix(resolvedRel, ".."+string(filepath.Separator)) {
+					return errors.New("File outside of the workspace: " + relPath)
+				}
+
+				sources = append(sources, &uploadSource{
+					path: filepath.ToSlash(relPath),
+					open: func() (io.ReadCloser, error) {
+						return os.Open(resolved)
+					},
+				})
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sources, nil
+}
+
+// artifactFiles returns the artifacts of the build matching the patterns
+func (c *cheopsImpl) artifactFiles(record *types.BuildRecord, patterns []string) ([]*uploadSource, error) {
+	sources := []*uploadSource{}
+	for _, artifact := range record.Artifacts {
+		for _, pattern := range patterns {
+			matched, err := path.Match(pattern, artifact.Path)
+			if err != nil {
+				return nil, err
+			}
+			if !matched {
+				continue
+			}
+
+			name := artifact.Path
+			sources = append(sources, &uploadSource{
+				path: name,
+				open: func() (io.ReadCloser, error) {
+					return c.artifacts.Open(record.ID, name)
+				},
+			})
+			break
+		}
+	}
+	return sources, nil
+}
+
+// uploadFiles runs an upload action
+func (c *cheopsImpl) uploadFiles(ctx context.Context, ctxt *types.BuildContext, action *types.Action, out io.Writer) error {
+	provider, ok := c.storageProviders[action.Provider]
+	if !ok {
+		return errors.New("Unknown provider: " + action.Provider)
+	}
+
+	sources, err := workspaceFiles(ctxt.RepoDir, action.Files)
+	if err != nil {
+		return err
+	}
+	artifactSources, err := c.artifactFiles(ctxt.Record, action.Artifacts)
+	if err != nil {
+		return err
+	}
+	sources = append(sources, artifactSources...)
+	if len(sources) == 0 {
+		return errors.New("No files to upload")
+	}
+
+	for _, source := range sources {
+		if ctx.Err() != nil {
+			return errBuildCancelled
+		}
+
+		file := &types.UploadFile{
+			Bucket:      action.Bucket,
+			Key:         uploadKey(action.Key, ctxt, source.path),
+			ContentType: uploadContentType(action, source.path),
+			Public:      action.Public,
+		}
+		log.WithFields(log.Fields{
+			"bucket": file.Bucket,
+			"key":    file.Key,
+		}).Debug("Uploading file")
+
+		body, err := source.open()
+		if err != nil {
+			return err
+		}
+		location, err := provider.Upload(ctx, file, body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "Uploaded %s to %s\n", source.path, location)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const usage = `Usage:
+  cheops                          Start the server
+  cheops rerun [flags] <build>    Re-run a past build
+  cheops replay [flags] <id>      Replay a stored webhook delivery
+
+Flags:
+`
+
+type apiClient struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (a *apiClient) post(path string) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(a.url, "/")+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body := struct {
+		ID    string
+		Error string
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", errors.New("Unexpected response: " + res.Status)
+	}
+	if body.Error != "" {
+		return "", errors.New(body.Error)
+	}
+
+	return body.ID, nil
+}
+
+// runCommand runs a client subcommand against the REST API of a running
+// server
+func runCommand(command string, args []string) error {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	url := flags.String("url", os.Getenv("CHEOPS_URL"), "server URL, defaults to $CHEOPS_URL")
+	token := flags.String("token", os.Getenv("CHEOPS_TOKEN"), "API token, defaults to $CHEOPS_TOKEN")
+	insecure := flags.Bool("insecure", false, "don't verify the server certificate")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+
+	var path string
+	switch command {
+	case "rerun":
+		path = "/api/builds/%s/rerun"
+	case "replay":
+		path = "/api/deliveries/%s/replay"
+	default:
+		flags.Usage()
+		return errors.New("Unknown command: " + command)
+	}
+
+	flags.Parse(args)
+	if flags.NArg() != 1 || *url == "" {
+		flags.Usage()
+		return errors.New("Missing server URL or ID")
+	}
+
+	client := &apiClient{
+		url:   *url,
+		token: *token,
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure},
+			},
+		},
+	}
+
+	id, err := client.post(fmt.Sprintf(path, flags.Arg(0)))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(id)
+	return nil
+}
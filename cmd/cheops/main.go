@@ -2,11 +2,21 @@ package main
 
 import (
 	"cheops/cheops"
+	"fmt"
+	"os"
 
 	log "github.com/sirupsen/logrus"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	log.SetLevel(log.DebugLevel)
 
 	log.Info("Starting Cheops")
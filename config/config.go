@@ -2,8 +2,10 @@ package config
 
 import (
 	"cheops/types"
+	"errors"
 	"io/ioutil"
 	"os"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 )
@@ -32,3 +34,29 @@ func LoadConfig() (*types.CheopsConfig, error) {
 
 	return parseConfig(configBytes)
 }
+
+// ReadSecret returns value if set, else the content of the env environment
+// variable or of file, whichever is set
+func ReadSecret(value, env, file string) (string, error) {
+	switch {
+	case value != "":
+		return value, nil
+
+	case env != "":
+		secret, ok := os.LookupEnv(env)
+		if !ok {
+			return "", errors.New("Environment variable not set: " + env)
+		}
+		return secret, nil
+
+	case file != "":
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+
+	default:
+		return "", nil
+	}
+}
@@ -1,42 +1,30 @@
 package config
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 )
 
 var sampleConfig = `
 general:
   webhook_url: https://cheops.io/
+  bind_addr: :8443
+
+repos:
+  - provider: github
+    url: https://github.com/patata/patat.git
+    branch: main
+    secrets:
+      env: prod
+  - provider: github
+    url: https://github.com/patata/patat.git
+    branches:
+      - develop
+      - feature/*
+    secrets:
+      env: staging
 
-builds:
-  - name: XX
-    repo:
-      type: github
-      url: https://github.com/patata/patat.git
-      branch: branch
-    containers:
-      - dockerfile: tato
-        context: .
-        name: container-1
-        args:
-          ARG1: patata
-
-      - dockerfile: bla
-        context: tato
-    actions:
-      - type: push
-        container: container-1
-        repo: 1848484.amazon.com/repo
-        provider: aws
-      - exec: container-2
-        commands:
-          - tato
-          - ls 
-    notifiers:
-      - type: github
-      - type: generic
-        url: tato
-      
 providers:
   git:
     - name: github
@@ -49,25 +37,78 @@ providers:
   docker_creds:
     - name: aws
       type: aws
-      secret_id: bla
+      aws_region: eu-west-1
 `
 
 func TestParseConfig(t *testing.T) {
 	config, err := parseConfig([]byte(sampleConfig))
 
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+
+	if config.General.WebhookURL != "https://cheops.io/" {
+		t.Error("Wrong webhook URL:", config.General.WebhookURL)
+	}
+
+	if len(config.Repos) != 2 {
+		t.Fatal("Expected 2 repos, got", len(config.Repos))
 	}
 
-	if config.Repos[0].URL != "XX" {
-		t.Error("uhm")
+	if config.Repos[0].URL != "https://github.com/patata/patat.git" {
+		t.Error("Wrong repo URL:", config.Repos[0].URL)
 	}
 
-	// if *config.Builds[0].Containers[0].Args["ARG1"] != "patata" {
-	// 	t.Error("Fail")
-	// }
+	if config.Repos[0].Branch != "main" {
+		t.Error("Wrong branch:", config.Repos[0].Branch)
+	}
+
+	if len(config.Repos[1].Branches) != 2 || config.Repos[1].Branches[1] != "feature/*" {
+		t.Error("Wrong branches:", config.Repos[1].Branches)
+	}
 
-	// if config.General.WebhookURL != "https://cheops.io/" {
-	// 	t.Error("Fail")
-	// }
+	if config.Repos[1].Secrets["env"] != "staging" {
+		t.Error("Wrong secrets:", config.Repos[1].Secrets)
+	}
+
+	if config.Providers.DockerCreds[0].AwsRegion != "eu-west-1" {
+		t.Error("Wrong AWS region:", config.Providers.DockerCreds[0].AwsRegion)
+	}
+}
+
+func TestReadSecret(t *testing.T) {
+	file, err := ioutil.TempFile("", "cheops-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("from-file\n")
+	file.Close()
+
+	os.Setenv("CHEOPS_TEST_SECRET", "from-env")
+	defer os.Unsetenv("CHEOPS_TEST_SECRET")
+
+	tests := []struct {
+		value, env, file string
+		expected         string
+	}{
+		{"from-config", "CHEOPS_TEST_SECRET", file.Name(), "from-config"},
+		{"", "CHEOPS_TEST_SECRET", file.Name(), "from-env"},
+		{"", "", file.Name(), "from-file"},
+		{"", "", "", ""},
+	}
+
+	for _, test := range tests {
+		secret, err := ReadSecret(test.value, test.env, test.file)
+		if err != nil {
+			t.Error(err)
+		}
+		if secret != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, secret)
+		}
+	}
+
+	if _, err := ReadSecret("", "CHEOPS_TEST_UNSET", ""); err == nil {
+		t.Error("Expected an error for an unset variable")
+	}
 }
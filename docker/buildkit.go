@@ -0,0 +1,346 @@
+package docker
+
+import (
+	"bytes"
+	"cheops/types"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	dockertypes "github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// sharedConfigEntries are the entries of the Docker CLI config directory
+// kept in the one of BuildKit builds, for the builders and the plugins
+var sharedConfigEntries = []string{"buildx", "cli-plugins", "contexts"}
+
+// dockerCommand returns the Docker CLI command for the daemon of config
+func dockerCommand(config *types.DockerConfig) []string {
+	command := []string{"docker"}
+	if config == nil {
+		return command
+	}
+
+	if config.Host != "" {
+		command = append(command, "--host", config.Host)
+	}
+	if config.TLSCert != "" || config.TLSCA != "" {
+		if config.TLSVerify {
+			command = append(command, "--tlsverify")
+		} else {
+			command = append(command, "--tls")
+		}
+		if config.TLSCA != "" {
+			command = append(command, "--tlscacert", config.TLSCA)
+		}
+		if config.TLSCert != "" {
+			command = append(command, "--tlscert", config.TLSCert, "--tlskey", config.TLSKey)
+		}
+	}
+	return command
+}
+
+// CacheImages returns the images of the registry caches of cache import and
+// export specs
+func CacheImages(specs []string) []string {
+	images := []string{}
+	for _, spec := range specs {
+		if !strings.Contains(spec, "=") {
+			// A bare image is a registry cache
+			images = append(images, spec)
+			continue
+		}
+
+		attrs := map[string]string{}
+		for _, field := range strings.Split(spec, ",") {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) == 2 {
+				attrs[parts[0]] = parts[1]
+			}
+		}
+		if attrs["type"] == "registry" && attrs["ref"] != "" {
+			images = append(images, attrs["ref"])
+		}
+	}
+	return images
+}
+
+// workspaceCacheSpec returns a cache import or export spec with the
+// directories of local caches resolved in the workspace, so that a
+// repository can't read or write other paths of the host
+func workspaceCacheSpec(spec, workspace string) (string, error) {
+	if !strings.Contains(spec, "=") {
+		return spec, nil
+	}
+
+	// Parsed the way docker buildx does, quotes included
+	fields, err := csv.NewReader(strings.NewReader(spec)).Read()
+	if err != nil {
+		return "", err
+	}
+
+	local := false
+	for _, field := range fields {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) == 2 && strings.ToLower(parts[0]) == "type" && parts[1] == "local" {
+			local = true
+		}
+	}
+	if !local {
+		return spec, nil
+	}
+
+	for i, field := range fields {
+		parts := strings.SplitN(field, "=", 2)
+		if key := strings.ToLower(parts[0]); len(parts) == 2 && (key == "src" || key == "dest") {
+			dir, err := workspacePath(workspace, parts[1])
+			if err != nil {
+				return "", err
+			}
+			fields[i] = key + "=" + dir
+		}
+	}
+
+	buf := bytes.Buffer{}
+	writer := csv.NewWriter(&buf)
+	writer.Write(fields)
+	writer.Flush()
+	return strings.TrimSuffix(buf.String(), "\n"), writer.Error()
+}
+
+// workspacePath returns a path relative to the workspace, as long as it
+// doesn't leave it, symbolic links included
+func workspacePath(workspace, path string) (string, error) {
+	outside := errors.New("Cache directory outside of the workspace: " + path)
+	if filepath.IsAbs(path) {
+		return "", outside
+	}
+	joined := filepath.Join(workspace, path)
+	if !inDir(workspace, joined) {
+		return "", outside
+	}
+
+	resolvedWorkspace, err := filepath.EvalSymlinks(workspace)
+	if err != nil {
+		return "", err
+	}
+
+	// Exports create the directory, check the part that exists
+	existing := joined
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			if !inDir(resolvedWorkspace, resolved) {
+				return "", outside
+			}
+			return joined, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		existing = filepath.Dir(existing)
+	}
+}
+
+// inDir reports whether path is dir or one of its descendants
+func inDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// buildKit builds the image with docker buildx and loads it in the daemon.
+// Secrets are passed in files of a temporary directory, removed after the
+// build, so they don't end up in the image.
+func (e *Engine) buildKit(ctx context.Context, options *types.BuildOptions, out io.Writer) (string, error) {
+	if e.docker == nil {
+		return "", errors.New("BuildKit builds are not supported by this engine")
+	}
+
+	dir, err := ioutil.TempDir("", "cheops-buildkit")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	configDir := filepath.Join(dir, "config")
+	if err := writeDockerConfig(configDir, options.Credentials); err != nil {
+		return "", err
+	}
+
+	iidFile := filepath.Join(dir, "iid")
+	args, err := buildxArgs(options, dir, iidFile)
+	if err != nil {
+		return "", err
+	}
+	command := append(append([]string{}, e.docker...), "buildx", "build")
+	if e.builder != "" {
+		command = append(command, "--builder", e.builder)
+	}
+	command = append(command, args...)
+
+	log.WithFields(log.Fields{
+		"tags":     options.Tags,
+		"platform": options.Platform,
+	}).Debug("Building image with BuildKit")
+
+	if err := runDocker(ctx, command, configDir, out, out); err != nil {
+		return "", err
+	}
+
+	imageID, err := ioutil.ReadFile(iidFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(imageID)), nil
+}
+
+// buildxArgs returns the arguments of docker buildx build for options, with
+// the secrets written to files of dir
+func buildxArgs(options *types.BuildOptions, dir, iidFile string) ([]string, error) {
+	args := []string{
+		"--progress", "plain",
+		"--iidfile", iidFile,
+		"--file", filepath.Join(options.ContextDir, options.Dockerfile),
+		"--load",
+	}
+	if options.Platform != "" {
+		args = append(args, "--platform", options.Platform)
+	}
+
+	for _, tag := range options.Tags {
+		args = append(args, "--tag", tag)
+	}
+
+	for _, name := range sortedKeys(options.Args) {
+		if value := options.Args[name]; value != nil {
+			args = append(args, "--build-arg", name+"="+*value)
+		} else {
+			args = append(args, "--build-arg", name)
+		}
+	}
+
+	secrets := make([]string, 0, len(options.Secrets))
+	for id := range options.Secrets {
+		secrets = append(secrets, id)
+	}
+	sort.Strings(secrets)
+	for i, id := range secrets {
+		file := filepath.Join(dir, fmt.Sprintf("secret-%d", i))
+		if err := ioutil.WriteFile(file, []byte(options.Secrets[id]), 0600); err != nil {
+			return nil, err
+		}
+		args = append(args, "--secret", "id="+id+",src="+file)
+	}
+
+	for _, ssh := range options.SSH {
+		args = append(args, "--ssh", ssh)
+	}
+	for _, cache := range options.CacheFrom {
+		spec, err := workspaceCacheSpec(cache, options.ContextDir)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--cache-from", spec)
+	}
+	for _, cache := range options.CacheTo {
+		spec, err := workspaceCacheSpec(cache, options.ContextDir)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--cache-to", spec)
+	}
+
+	return append(args, options.ContextDir), nil
+}
+
+// runDocker runs a Docker CLI command with the config directory
+func runDocker(ctx context.Context, command []string, configDir string, stdout, stderr io.Writer) error {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), "DOCKER_CONFIG="+configDir, "DOCKER_BUILDKIT=1")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+func sortedKeys(values map[string]*string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// configAuth is a credentials entry of a Docker CLI config file
+type configAuth struct {
+	Auth          string `json:"auth,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+	RegistryToken string `json:"registrytoken,omitempty"`
+}
+
+// writeDockerConfig creates a Docker CLI config directory holding the
+// credentials, keyed by registry host, and sharing the builders and plugins
+// of the config directory of the user
+func writeDockerConfig(dir string, credentials map[string]string) error {
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return err
+	}
+
+	userDir := os.Getenv("DOCKER_CONFIG")
+	if userDir == "" {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			userDir = filepath.Join(home, ".docker")
+		}
+	}
+	if userDir != "" {
+		for _, entry := range sharedConfigEntries {
+			path := filepath.Join(userDir, entry)
+			if _, err := os.Stat(path); err == nil {
+				if err := os.Symlink(path, filepath.Join(dir, entry)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	auths := map[string]configAuth{}
+	for registry, creds := range credentials {
+		auth := dockertypes.AuthConfig{}
+		if err := json.Unmarshal([]byte(creds), &auth); err != nil {
+			return err
+		}
+
+		entry := configAuth{
+			IdentityToken: auth.IdentityToken,
+			RegistryToken: auth.RegistryToken,
+		}
+		if auth.Username != "" || auth.Password != "" {
+			entry.Auth = base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+		}
+		auths[ServerAddress(registry)] = entry
+	}
+
+	config, err := json.Marshal(map[string]interface{}{"auths": auths})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "config.json"), config, 0600)
+}
@@ -0,0 +1,183 @@
+package docker
+
+import (
+	"bytes"
+	"cheops/types"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDockerCommand(t *testing.T) {
+	tests := []struct {
+		config   *types.DockerConfig
+		expected string
+	}{
+		{nil, "docker"},
+		{&types.DockerConfig{Builder: "cache"}, "docker"},
+		{
+			&types.DockerConfig{Host: "tcp://10.0.0.2:2376", TLSCA: "ca.pem", TLSCert: "cert.pem", TLSKey: "key.pem", TLSVerify: true},
+			"docker --host tcp://10.0.0.2:2376 --tlsverify --tlscacert ca.pem --tlscert cert.pem --tlskey key.pem",
+		},
+	}
+
+	for _, test := range tests {
+		if command := strings.Join(dockerCommand(test.config), " "); command != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, command)
+		}
+	}
+}
+
+func TestCacheImages(t *testing.T) {
+	specs := []string{
+		"ghcr.io/octo/app:cache",
+		"type=registry,ref=123.dkr.ecr.eu-west-1.amazonaws.com/app:cache,mode=max",
+		"type=local,src=/var/cache/app",
+		"type=gha",
+	}
+
+	expected := []string{"ghcr.io/octo/app:cache", "123.dkr.ecr.eu-west-1.amazonaws.com/app:cache"}
+	if images := CacheImages(specs); !reflect.DeepEqual(images, expected) {
+		t.Error("Wrong images:", images)
+	}
+}
+
+func TestWorkspaceCacheSpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cheops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	workspace := filepath.Join(dir, "repo")
+	os.MkdirAll(filepath.Join(workspace, ".cache"), 0755)
+	os.Symlink(dir, filepath.Join(workspace, "host"))
+
+	tests := map[string]string{
+		"app:cache":                            "app:cache",
+		"type=registry,ref=app:cache,mode=max": "type=registry,ref=app:cache,mode=max",
+		"type=local,src=.cache":                "type=local,src=" + filepath.Join(workspace, ".cache"),
+		"type=local,dest=.cache/new,mode=max":  "type=local,dest=" + filepath.Join(workspace, ".cache", "new") + ",mode=max",
+	}
+	for spec, expected := range tests {
+		if actual, err := workspaceCacheSpec(spec, workspace); err != nil || actual != expected {
+			t.Errorf("Expected %s for %s, got %s %v", expected, spec, actual, err)
+		}
+	}
+
+	for _, spec := range []string{
+		"type=local,src=/var/cache/app",
+		"type=local,dest=../cache",
+		`type=local,"dest=/etc/cron.d"`,
+		"type=local,dest=host/cache",
+		"TYPE=local,SRC=host",
+	} {
+		if _, err := workspaceCacheSpec(spec, workspace); err == nil ||
+			!strings.HasPrefix(err.Error(), "Cache directory outside of the workspace") {
+			t.Error("Expected an error for", spec, "got", err)
+		}
+	}
+}
+
+// fakeBuildx records its arguments, config and secret in CHEOPS_TEST_DIR
+const fakeBuildx = `#!/bin/sh
+printf '%s\n' "$@" > "$CHEOPS_TEST_DIR/args"
+cp "$DOCKER_CONFIG/config.json" "$CHEOPS_TEST_DIR/config.json"
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--iidfile) echo sha256:7f3e > "$2"; shift ;;
+	--secret) cat "${2##*src=}" > "$CHEOPS_TEST_DIR/secret"; shift ;;
+	esac
+	shift
+done
+echo "#1 [internal] load build definition from Dockerfile"
+`
+
+func TestBuildKit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "buildx")
+	ioutil.WriteFile(script, []byte(fakeBuildx), 0755)
+	os.Setenv("CHEOPS_TEST_DIR", dir)
+	defer os.Unsetenv("CHEOPS_TEST_DIR")
+
+	engine := &Engine{authKey: ServerAddress, docker: []string{script}, builder: "multiarch"}
+	version := "1.2"
+	out := bytes.Buffer{}
+	imageID, err := engine.BuildImage(context.Background(), &types.BuildOptions{
+		BuildKit:    true,
+		ContextDir:  "/src",
+		Dockerfile:  "Dockerfile",
+		Tags:        []string{"app:1.2", "app:latest"},
+		Args:        map[string]*string{"VERSION": &version},
+		Credentials: map[string]string{DockerHub: `{"username":"octo","password":"secret"}`},
+		Secrets:     map[string]string{"npm_token": "s3cr3t"},
+		SSH:         []string{"default"},
+		CacheFrom:   []string{"type=registry,ref=app:cache"},
+		CacheTo:     []string{"type=registry,ref=app:cache,mode=max"},
+		Platform:    "linux/arm64",
+	}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if imageID != "sha256:7f3e" {
+		t.Error("Wrong image ID:", imageID)
+	}
+	if out.String() != "#1 [internal] load build definition from Dockerfile\n" {
+		t.Error("Wrong output:", out.String())
+	}
+
+	args, _ := ioutil.ReadFile(filepath.Join(dir, "args"))
+	lines := strings.Split(strings.TrimSpace(string(args)), "\n")
+	secretArg := lines[20]
+	lines[7], lines[20] = "<iidfile>", "<secret>"
+	expected := []string{
+		"buildx", "build", "--builder", "multiarch",
+		"--progress", "plain", "--iidfile", "<iidfile>", "--file", "/src/Dockerfile", "--load",
+		"--platform", "linux/arm64",
+		"--tag", "app:1.2", "--tag", "app:latest", "--build-arg", "VERSION=1.2",
+		"--secret", "<secret>", "--ssh", "default",
+		"--cache-from", "type=registry,ref=app:cache", "--cache-to", "type=registry,ref=app:cache,mode=max",
+		"/src",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Error("Wrong arguments:", lines)
+	}
+	if !strings.HasPrefix(secretArg, "id=npm_token,src=") {
+		t.Error("Wrong secret argument:", secretArg)
+	}
+
+	if secret, _ := ioutil.ReadFile(filepath.Join(dir, "secret")); string(secret) != "s3cr3t" {
+		t.Error("Wrong secret:", string(secret))
+	}
+	if _, err := os.Stat(secretArg[strings.Index(secretArg, "src=")+4:]); !os.IsNotExist(err) {
+		t.Error("Secret file not removed:", secretArg)
+	}
+
+	config := struct {
+		Auths map[string]configAuth
+	}{}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	json.Unmarshal(data, &config)
+	if auth := config.Auths[dockerHubServer].Auth; auth != "b2N0bzpzZWNyZXQ=" {
+		t.Error("Wrong auth:", string(data))
+	}
+}
+
+func TestBuildKitUnsupported(t *testing.T) {
+	engine := &Engine{authKey: ServerAddress}
+	_, err := engine.BuildImage(context.Background(), &types.BuildOptions{BuildKit: true}, ioutil.Discard)
+	if err == nil {
+		t.Error("Expected an error without the Docker CLI")
+	}
+}
@@ -0,0 +1,43 @@
+package docker
+
+import (
+	"cheops/types"
+	"net/http"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+// newClient creates a client for the daemon of config, or the one of the
+// environment when no host is set
+func newClient(config *types.DockerConfig) (*client.Client, error) {
+	if config == nil || config.Host == "" {
+		return client.NewEnvClient()
+	}
+
+	var httpClient *http.Client
+	if config.TLSCert != "" || config.TLSCA != "" {
+		tlsc, err := tlsconfig.Client(tlsconfig.Options{
+			CAFile:             config.TLSCA,
+			CertFile:           config.TLSCert,
+			KeyFile:            config.TLSKey,
+			InsecureSkipVerify: !config.TLSVerify,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		httpClient = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: tlsc,
+			},
+		}
+	}
+
+	version := config.APIVersion
+	if version == "" {
+		version = client.DefaultVersion
+	}
+
+	return client.NewClient(config.Host, version, httpClient, nil)
+}
@@ -1,17 +1,17 @@
 package docker
 
 import (
-	"bufio"
+	"cheops/types"
 	"context"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
-	"github.com/docker/docker/api/types"
+	dockertypes "github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
@@ -22,140 +22,312 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func streamDockerOutput(reader io.ReadCloser) error {
-	scanner := bufio.NewScanner(reader)
-	defer reader.Close()
-	for scanner.Scan() {
-		line := scanner.Bytes()
-
-		data := make(map[string]interface{})
-		err := json.Unmarshal(line, &data)
-		if err != nil {
-			return err
-		}
+// Engine runs builds on a Docker daemon, or a Podman service through its
+// Docker compatible API
+type Engine struct {
+	cli *client.Client
+	// authKey returns the key of the build credentials of a registry host
+	authKey func(registry string) string
+	// docker is the Docker CLI command for the daemon, used for BuildKit
+	// builds and manifest lists, nil when not supported
+	docker []string
+	// builder is the docker buildx builder of BuildKit builds
+	builder string
+}
 
-		if data["stream"] != nil {
-			log.Info(data["stream"])
-		} else if data["status"] != nil {
-			status := data["status"].(string)
-			if data["id"] != nil {
-				id := data["id"].(string)
-				log.WithFields(log.Fields{"id": id}).Info(status)
-			} else {
-				log.Info(status)
-			}
-		} else if data["error"] != nil {
-			return errors.New(data["error"].(string))
-		}
+// New creates an engine for the daemon of config, or the one of the
+// environment (DOCKER_HOST, DOCKER_CERT_PATH...) when no host is set
+func New(config *types.DockerConfig) (*Engine, error) {
+	cli, err := newClient(config)
+	if err != nil {
+		return nil, err
 	}
 
-	return scanner.Err()
+	engine := &Engine{cli: cli, authKey: ServerAddress, docker: dockerCommand(config)}
+	if config != nil {
+		engine.builder = config.Builder
+	}
+	return engine, nil
 }
 
-type logWriter struct {
-	stream string
-}
+// pullMissingImage pulls the image unless it's already present, e.g.
+// because it was built earlier in the build
+func (e *Engine) pullMissingImage(ctx context.Context, image, credentials string, out io.Writer) error {
+	_, _, err := e.cli.ImageInspectWithRaw(ctx, image)
+	if err == nil || !client.IsErrImageNotFound(err) {
+		return err
+	}
 
-func (l *logWriter) Write(data []byte) (int, error) {
 	log.WithFields(log.Fields{
-		"stream": l.stream,
-	}).Info(string(data))
-	return len(data), nil
+		"image": image,
+	}).Info("Pulling image")
+
+	reader, err := e.cli.ImagePull(ctx, image, dockertypes.ImagePullOptions{
+		RegistryAuth: base64.StdEncoding.EncodeToString([]byte(credentials)),
+	})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = decodeStream(reader, out)
+	return err
 }
 
-func RunContainer(image string, commands, env []string) error {
+// RunContainer runs the commands in a container of the image, pulling it
+// with the credentials if it's missing
+func (e *Engine) RunContainer(ctx context.Context, options *types.RunOptions, out io.Writer) error {
 	log.WithFields(log.Fields{
-		"Image":    image,
-		"Commands": commands,
+		"Image":    options.Image,
+		"Commands": options.Commands,
 	}).Debug("Running container")
 
-	cli, err := client.NewEnvClient()
+	err := e.pullMissingImage(ctx, options.Image, options.Credentials, out)
 	if err != nil {
 		return err
 	}
 
-	commandsShell := []string{"/bin/sh", "-c", strings.Join(commands, ";")}
+	commandsShell := []string{"/bin/sh", "-c", strings.Join(options.Commands, ";")}
 
-	cont, err := cli.ContainerCreate(
-		context.TODO(),
+	cont, err := e.cli.ContainerCreate(
+		ctx,
 		&container.Config{
-			Image:        image,
+			Image:        options.Image,
 			Cmd:          commandsShell,
+			Env:          options.Env,
 			Tty:          false,
 			AttachStdout: true,
 			AttachStderr: true,
 		},
-		&container.HostConfig{
-			AutoRemove: true,
-		},
+		// The container is removed once its exit status is known and its
+		// artifacts are copied
+		&container.HostConfig{},
 		&network.NetworkingConfig{},
-		"container",
+		"",
 	)
 	if err != nil {
 		return err
 	}
+	defer e.removeContainer(cont.ID)
 
-	err = cli.ContainerStart(context.TODO(), cont.ID, types.ContainerStartOptions{})
+	err = e.cli.ContainerStart(ctx, cont.ID, dockertypes.ContainerStartOptions{})
 	if err != nil {
 		return err
 	}
 
-	res, err := cli.ContainerLogs(context.TODO(), cont.ID, types.ContainerLogsOptions{
+	res, err := e.cli.ContainerLogs(ctx, cont.ID, dockertypes.ContainerLogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
+		Follow:     true,
 	})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
 
-	stdoutWriter := &logWriter{stream: "stdout"}
-	stderrWriter := &logWriter{stream: "stderr"}
+	_, err = stdcopy.StdCopy(out, out, res)
+	if ctx.Err() != nil {
+		// The build was cancelled, don't leave the container running
+		e.cli.ContainerKill(context.Background(), cont.ID, "KILL")
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
 
-	stdcopy.StdCopy(stdoutWriter, stderrWriter, res)
+	status, err := e.cli.ContainerWait(ctx, cont.ID)
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		return fmt.Errorf("Commands exited with status %d", status)
+	}
 
+	for _, path := range options.Artifacts {
+		if err := e.copyArtifact(ctx, cont.ID, path, options.CopyArtifact); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func PushImage(image, credentials string) error {
+func (e *Engine) copyArtifact(ctx context.Context, containerID, path string, copyArtifact func(string, io.Reader) error) error {
+	reader, _, err := e.cli.CopyFromContainer(ctx, containerID, path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return copyArtifact(path, reader)
+}
+
+func (e *Engine) removeContainer(containerID string) {
+	err := e.cli.ContainerRemove(context.Background(), containerID, dockertypes.ContainerRemoveOptions{
+		Force: true,
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"container": containerID,
+			"error":     err,
+		}).Warn("Can't remove container")
+	}
+}
+
+// containerArchive is a tar archive copied out of a container, which is
+// removed on Close
+type containerArchive struct {
+	io.ReadCloser
+	engine      *Engine
+	containerID string
+}
+
+func (a *containerArchive) Close() error {
+	err := a.ReadCloser.Close()
+	a.engine.removeContainer(a.containerID)
+	return err
+}
+
+// CopyFromImage returns a tar archive of a path of the image, copied out of
+// a container that is never started
+func (e *Engine) CopyFromImage(ctx context.Context, image, path string) (io.ReadCloser, error) {
 	log.WithFields(log.Fields{
 		"image": image,
-	}).Info("Pushing image")
+		"path":  path,
+	}).Debug("Copying from image")
+
+	cont, err := e.cli.ContainerCreate(
+		ctx,
+		&container.Config{
+			Image: image,
+			// Images without a command, e.g. built from scratch, can't be
+			// created without one
+			Cmd: []string{"/bin/true"},
+		},
+		&container.HostConfig{},
+		&network.NetworkingConfig{},
+		"",
+	)
+	if err != nil {
+		return nil, err
+	}
 
-	cli, err := client.NewEnvClient()
+	reader, _, err := e.cli.CopyFromContainer(ctx, cont.ID, path)
 	if err != nil {
-		return err
+		e.removeContainer(cont.ID)
+		return nil, err
 	}
+	return &containerArchive{reader, e, cont.ID}, nil
+}
+
+// PushImage pushes the image and returns the digest of the pushed manifest
+func (e *Engine) PushImage(ctx context.Context, image, credentials string, out io.Writer) (string, error) {
+	log.WithFields(log.Fields{
+		"image": image,
+	}).Info("Pushing image")
 
 	credentialsEnc := base64.StdEncoding.EncodeToString([]byte(credentials))
-	reader, err := cli.ImagePush(context.TODO(), image, types.ImagePushOptions{
+	reader, err := e.cli.ImagePush(ctx, image, dockertypes.ImagePushOptions{
 		RegistryAuth: credentialsEnc,
 	})
 
 	if err != nil {
-		return err
+		return "", err
+	}
+	defer reader.Close()
+
+	result, err := decodeStream(reader, out)
+	if err != nil || result.Digest != "" {
+		return result.Digest, err
 	}
 
-	return streamDockerOutput(reader)
+	// Older Podman versions don't report the digest in the stream
+	return e.repoDigest(ctx, image)
 }
 
-func BuildImage(repoPath, dockerfilePath string, tags []string, args map[string]*string) error {
-	cli, err := client.NewEnvClient()
+// repoDigest returns the digest of the image in its repository
+func (e *Engine) repoDigest(ctx context.Context, image string) (string, error) {
+	info, _, err := e.cli.ImageInspectWithRaw(ctx, image)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	repository := FullRepository(image)
+	for _, repoDigest := range info.RepoDigests {
+		parts := strings.SplitN(repoDigest, "@", 2)
+		if len(parts) == 2 && FullRepository(parts[0]) == repository {
+			return parts[1], nil
+		}
+	}
+	return "", nil
+}
+
+// TagImage adds the target reference to the source image
+func (e *Engine) TagImage(ctx context.Context, source, target string) error {
+	log.WithFields(log.Fields{
+		"source": source,
+		"target": target,
+	}).Debug("Tagging image")
+
+	return e.cli.ImageTag(ctx, source, target)
+}
+
+// RemoveImage removes an image reference, and the image if it was the last
+// one
+func (e *Engine) RemoveImage(ctx context.Context, image string) error {
+	log.WithFields(log.Fields{
+		"image": image,
+	}).Debug("Removing image")
+
+	_, err := e.cli.ImageRemove(ctx, image, dockertypes.ImageRemoveOptions{})
+	return err
+}
+
+// authConfigs converts the credentials returned by the credentials
+// providers, keyed by registry host, to the auth configs of a build
+func (e *Engine) authConfigs(credentials map[string]string) (map[string]dockertypes.AuthConfig, error) {
+	configs := map[string]dockertypes.AuthConfig{}
+	for registry, creds := range credentials {
+		auth := dockertypes.AuthConfig{}
+		if err := json.Unmarshal([]byte(creds), &auth); err != nil {
+			return nil, err
+		}
+		configs[e.authKey(registry)] = auth
+	}
+	return configs, nil
+}
+
+// BuildImage builds the image and returns its ID
+func (e *Engine) BuildImage(ctx context.Context, options *types.BuildOptions, out io.Writer) (string, error) {
+	if options.BuildKit {
+		return e.buildKit(ctx, options, out)
+	}
+
+	auths, err := e.authConfigs(options.Credentials)
+	if err != nil {
+		return "", err
 	}
 	reader, writer := io.Pipe()
 
 	g := errgroup.Group{}
 
+	imageID := ""
 	g.Go(func() error {
-		info, err := cli.ImageBuild(context.TODO(), reader, types.ImageBuildOptions{
-			Tags:       tags,
-			Dockerfile: dockerfilePath,
-			BuildArgs:  args,
-			PullParent: true,
+		info, err := e.cli.ImageBuild(ctx, reader, dockertypes.ImageBuildOptions{
+			Tags:        options.Tags,
+			Dockerfile:  options.Dockerfile,
+			BuildArgs:   options.Args,
+			PullParent:  true,
+			AuthConfigs: auths,
 		})
 		if err != nil {
 			return err
 		}
 
-		return streamDockerOutput(info.Body)
+		defer info.Body.Close()
+
+		result, err := decodeStream(info.Body, out)
+		imageID = result.ImageID
+		return err
 	})
 
 	g.Go(func() error {
@@ -168,13 +340,13 @@ func BuildImage(repoPath, dockerfilePath string, tags []string, args map[string]
 		}()
 
 		return filepath.Walk(
-			repoPath,
+			options.ContextDir,
 			func(path string, info os.FileInfo, err error) error {
 				if err != nil {
 					return err
 				}
 
-				relPath, err := filepath.Rel(repoPath, path)
+				relPath, err := filepath.Rel(options.ContextDir, path)
 				if err != nil {
 					return err
 				}
@@ -201,10 +373,6 @@ func BuildImage(repoPath, dockerfilePath string, tags []string, args map[string]
 		)
 	})
 
-	return g.Wait()
+	err = g.Wait()
+	return imageID, err
 }
-
-// func main() {
-// 	err := buildImage("https://github.com/thepeak99/nomad-docker", "Dockerfile", []string{"salam"}, nil)
-// 	fmt.Println(err)
-// }
@@ -0,0 +1,99 @@
+package docker
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// BaseImages returns the images the stages of a Dockerfile are built from,
+// without scratch and references to earlier stages. Variables are expanded
+// from the ARG instructions before the first FROM and from args.
+func BaseImages(path string, args map[string]*string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	vars := map[string]string{}
+	stages := map[string]bool{}
+	images := []string{}
+	seenFrom := false
+
+	for _, line := range instructions(file) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			if seenFrom {
+				continue
+			}
+			parts := strings.SplitN(fields[1], "=", 2)
+			value := ""
+			if len(parts) == 2 {
+				value = strings.Trim(parts[1], `"'`)
+			}
+			if arg, ok := args[parts[0]]; ok && arg != nil {
+				value = *arg
+			}
+			vars[parts[0]] = value
+
+		case "FROM":
+			seenFrom = true
+			fields = fields[1:]
+			for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+				fields = fields[1:]
+			}
+			if len(fields) == 0 {
+				continue
+			}
+
+			image := os.Expand(fields[0], func(name string) string {
+				return vars[name]
+			})
+			if image != "" && image != "scratch" && !stages[strings.ToLower(image)] {
+				images = append(images, image)
+			}
+			if len(fields) == 3 && strings.EqualFold(fields[1], "AS") {
+				stages[strings.ToLower(fields[2])] = true
+			}
+		}
+	}
+
+	return images, nil
+}
+
+// instructions returns the instructions of a Dockerfile, with continuation
+// lines joined and comments removed
+func instructions(file *os.File) []string {
+	result := []string{}
+	current := ""
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasSuffix(line, "\\") {
+			current += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+
+		current += line
+		if current != "" {
+			result = append(result, current)
+		}
+		current = ""
+	}
+	if current != "" {
+		result = append(result, current)
+	}
+
+	return result
+}
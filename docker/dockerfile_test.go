@@ -0,0 +1,21 @@
+package docker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBaseImages(t *testing.T) {
+	runtime := "123456789012.dkr.ecr.eu-west-1.amazonaws.com/base:latest"
+	images, err := BaseImages("testdata/Dockerfile.multistage", map[string]*string{
+		"RUNTIME": &runtime,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"golang:1.13", runtime}
+	if !reflect.DeepEqual(images, expected) {
+		t.Error("Expected", expected, "got", images)
+	}
+}
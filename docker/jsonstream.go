@@ -0,0 +1,241 @@
+package docker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// maxLineSize is the longest message of the stream, build output lines can
+// be long
+const maxLineSize = 1024 * 1024
+
+var (
+	fullID       = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	digestStatus = regexp.MustCompile(`: digest: (sha256:[0-9a-f]{64}) size: \d+$`)
+)
+
+const successfullyBuilt = "Successfully built "
+
+// progressStep is the percentage between two aggregated progress lines
+const progressStep = 25
+
+type jsonError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type progressDetail struct {
+	Current int64 `json:"current"`
+	Total   int64 `json:"total"`
+}
+
+// jsonMessage is a message of the JSON stream the daemon returns for builds,
+// pulls and pushes
+type jsonMessage struct {
+	Stream         string          `json:"stream"`
+	Status         string          `json:"status"`
+	ID             string          `json:"id"`
+	ProgressDetail *progressDetail `json:"progressDetail"`
+	Error          string          `json:"error"`
+	ErrorDetail    *jsonError      `json:"errorDetail"`
+	Aux            json.RawMessage `json:"aux"`
+}
+
+type auxMessage struct {
+	// ID is the image ID of builds
+	ID string
+	// Digest is the manifest digest of pushes
+	Digest string
+}
+
+// streamResult is what a stream reports besides its output
+type streamResult struct {
+	ImageID string
+	Digest  string
+}
+
+// progress aggregates the progress of the layers for a status, such as
+// Downloading or Pushing
+type progress struct {
+	layers map[string]*progressDetail
+	// bucket is the last printed percentage step
+	bucket int64
+}
+
+type streamDecoder struct {
+	out    io.Writer
+	result streamResult
+	// fromOutput is set when the image ID was read from the output rather
+	// than an aux message
+	fromOutput bool
+	// statuses are the last status of each layer
+	statuses map[string]string
+	progress map[string]*progress
+}
+
+// decodeStream copies the JSON message stream returned by the daemon to out,
+// with layer progress aggregated, and returns the image ID and digest it
+// reports. Error messages are returned as errors.
+func decodeStream(reader io.Reader, out io.Writer) (*streamResult, error) {
+	d := &streamDecoder{
+		out:      out,
+		statuses: map[string]string{},
+		progress: map[string]*progress{},
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		message := jsonMessage{}
+		if err := json.Unmarshal(line, &message); err != nil {
+			// Not part of the protocol, but better shown than dropped
+			fmt.Fprintln(out, string(line))
+			continue
+		}
+
+		if err := d.handle(&message); err != nil {
+			return &d.result, err
+		}
+	}
+
+	return &d.result, scanner.Err()
+}
+
+func (d *streamDecoder) handle(message *jsonMessage) error {
+	if message.ErrorDetail != nil || message.Error != "" {
+		return streamError(message)
+	}
+
+	if len(message.Aux) > 0 {
+		aux := auxMessage{}
+		if json.Unmarshal(message.Aux, &aux) == nil {
+			if aux.ID != "" {
+				d.result.ImageID = aux.ID
+				d.fromOutput = false
+			}
+			if aux.Digest != "" {
+				d.result.Digest = aux.Digest
+			}
+		}
+	}
+
+	switch {
+	case message.Stream != "":
+		fmt.Fprint(d.out, message.Stream)
+		d.streamImageID(message.Stream)
+
+	case message.Status != "" && message.ID == "":
+		status := strings.TrimRight(message.Status, "\n")
+		fmt.Fprintln(d.out, status)
+		d.statusDigest(status)
+
+	case message.Status != "":
+		d.layerStatus(message)
+	}
+
+	return nil
+}
+
+// streamImageID gets the image ID of builds from the output when there's no
+// aux message, Podman ends its output with the full ID and Docker with a
+// "Successfully built" line
+func (d *streamDecoder) streamImageID(stream string) {
+	if d.result.ImageID != "" && !d.fromOutput {
+		return
+	}
+
+	line := strings.TrimSpace(stream)
+	if fullID.MatchString(line) {
+		d.result.ImageID = "sha256:" + line
+		d.fromOutput = true
+	} else if strings.HasPrefix(line, successfullyBuilt) && d.result.ImageID == "" {
+		d.result.ImageID = strings.TrimPrefix(line, successfullyBuilt)
+		d.fromOutput = true
+	}
+}
+
+// statusDigest gets the digest of pushes from the "<tag>: digest: <digest>
+// size: <size>" status when there's no aux message
+func (d *streamDecoder) statusDigest(status string) {
+	if d.result.Digest != "" {
+		return
+	}
+
+	if match := digestStatus.FindStringSubmatch(status); match != nil {
+		d.result.Digest = match[1]
+	}
+}
+
+func streamError(message *jsonMessage) error {
+	text := message.Error
+	code := 0
+	if message.ErrorDetail != nil {
+		if message.ErrorDetail.Message != "" {
+			text = message.ErrorDetail.Message
+		}
+		code = message.ErrorDetail.Code
+	}
+
+	if code != 0 {
+		return fmt.Errorf("%s (code %d)", text, code)
+	}
+	return fmt.Errorf("%s", text)
+}
+
+// layerStatus prints the status of a layer when it changes, and the
+// aggregated progress of all the layers in the same status
+func (d *streamDecoder) layerStatus(message *jsonMessage) {
+	if d.statuses[message.ID] != message.Status {
+		d.statuses[message.ID] = message.Status
+		fmt.Fprintln(d.out, message.ID+": "+message.Status)
+	}
+
+	detail := message.ProgressDetail
+	if detail == nil || detail.Total <= 0 {
+		return
+	}
+
+	p, ok := d.progress[message.Status]
+	if !ok {
+		p = &progress{layers: map[string]*progressDetail{}}
+		d.progress[message.Status] = p
+	}
+	p.layers[message.ID] = detail
+
+	var current, total int64
+	for _, layer := range p.layers {
+		current += layer.Current
+		total += layer.Total
+	}
+
+	bucket := current * 100 / total / progressStep
+	if bucket > p.bucket {
+		p.bucket = bucket
+		fmt.Fprintf(d.out, "%s %d%% of %s\n", message.Status, bucket*progressStep, formatSize(total))
+	}
+}
+
+func formatSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	value := float64(size)
+	for _, suffix := range []string{"kB", "MB", "GB"} {
+		value /= unit
+		if value < unit || suffix == "GB" {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+package docker
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func decodeFile(t *testing.T, name string) (*streamResult, string, error) {
+	file, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	out := bytes.Buffer{}
+	result, err := decodeStream(file, &out)
+	return result, out.String(), err
+}
+
+func TestDecodeBuild(t *testing.T) {
+	result, out, err := decodeFile(t, "build-success.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.ImageID != "sha256:7f3e1b2a9c8d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f" {
+		t.Error("Wrong image ID:", result.ImageID)
+	}
+	if !strings.HasPrefix(out, "Step 1/3 : FROM alpine:3.10\n ---> 965ea09ff2eb\n") ||
+		!strings.Contains(out, "\nhello\n") || !strings.HasSuffix(out, "Successfully tagged app:latest\n") {
+		t.Error("Wrong output:", out)
+	}
+}
+
+func TestDecodeBuildError(t *testing.T) {
+	_, out, err := decodeFile(t, "build-error.jsonl")
+	if err == nil || err.Error() != "The command '/bin/sh -c false' returned a non-zero code: 1 (code 1)" {
+		t.Error("Wrong error:", err)
+	}
+	if strings.Contains(out, "never printed") {
+		t.Error("Output after the error:", out)
+	}
+}
+
+func TestDecodePull(t *testing.T) {
+	_, out, err := decodeFile(t, "pull.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `3.10: Pulling from library/alpine
+89d9c30c1d48: Pulling fs layer
+5d20c808ce19: Pulling fs layer
+89d9c30c1d48: Downloading
+Downloading 25% of 2.0 MB
+Downloading 50% of 2.0 MB
+5d20c808ce19: Downloading
+Downloading 75% of 4.0 MB
+5d20c808ce19: Download complete
+Downloading 100% of 4.0 MB
+89d9c30c1d48: Download complete
+89d9c30c1d48: Pull complete
+5d20c808ce19: Pull complete
+Digest: sha256:c19173c5ada610a5989151111163d28a67368362762534d8a8121ce95cf2bd5a
+Status: Downloaded newer image for alpine:3.10
+`
+	if out != expected {
+		t.Errorf("Wrong output:\n%s", out)
+	}
+}
+
+func TestDecodePush(t *testing.T) {
+	result, out, err := decodeFile(t, "push.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Digest != "sha256:5a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f9" {
+		t.Error("Wrong digest:", result.Digest)
+	}
+	if !strings.Contains(out, "77cae8ab23bf: Layer already exists\nthis line is not JSON\n") {
+		t.Error("Wrong output:", out)
+	}
+}
+
+func TestDecodePodmanBuild(t *testing.T) {
+	result, out, err := decodeFile(t, "podman-build.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.ImageID != "sha256:7f3e1b2a9c8d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f" {
+		t.Error("Wrong image ID:", result.ImageID)
+	}
+	if !strings.HasPrefix(out, "STEP 1/3: FROM docker.io/library/alpine:3.10\n") {
+		t.Error("Wrong output:", out)
+	}
+}
+
+func TestDecodePodmanPush(t *testing.T) {
+	result, out, err := decodeFile(t, "podman-push.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Digest != "sha256:5a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f9" {
+		t.Error("Wrong digest:", result.Digest)
+	}
+	if !strings.HasPrefix(out, "Getting image source signatures\nCopying blob") {
+		t.Error("Wrong output:", out)
+	}
+}
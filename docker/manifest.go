@@ -0,0 +1,66 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PushManifestList creates a manifest list of manifests pushed to the
+// repository of image, which the registry reads the platforms from, and
+// pushes it under image
+func (e *Engine) PushManifestList(ctx context.Context, image string, manifests []string, credentials string, out io.Writer) (string, error) {
+	if e.docker == nil {
+		return "", errors.New("Manifest lists are not supported by this engine")
+	}
+
+	log.WithFields(log.Fields{
+		"image":     image,
+		"manifests": manifests,
+	}).Info("Pushing manifest list")
+
+	// The manifest lists of the Docker CLI are stored in its config
+	// directory, a temporary one keeps builds from sharing them
+	dir, err := ioutil.TempDir("", "cheops-manifest")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	configDir := filepath.Join(dir, "config")
+	err = writeDockerConfig(configDir, map[string]string{RegistryHost(image): credentials})
+	if err != nil {
+		return "", err
+	}
+
+	create := append(append([]string{}, e.docker...), "manifest", "create", image)
+	create = append(create, manifests...)
+	if err := runDocker(ctx, create, configDir, out, out); err != nil {
+		return "", err
+	}
+
+	// The output is copied once the command exits, so that out isn't
+	// written to from the stdout and stderr goroutines at once
+	output := bytes.Buffer{}
+	push := append(append([]string{}, e.docker...), "manifest", "push", "--purge", image)
+	err = runDocker(ctx, push, configDir, &output, out)
+	out.Write(output.Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	// The digest is the last line of the output
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	last := strings.TrimSpace(lines[len(lines)-1])
+	if !strings.HasPrefix(last, "sha256:") {
+		return "", nil
+	}
+	return last, nil
+}
@@ -0,0 +1,64 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeManifest records its invocations in CHEOPS_TEST_DIR and prints a
+// digest when pushing
+const fakeManifest = `#!/bin/sh
+echo "$@" >> "$CHEOPS_TEST_DIR/calls"
+ls "$DOCKER_CONFIG" >> "$CHEOPS_TEST_DIR/calls"
+if [ "$2" = push ]; then
+	echo sha256:0f1e2d3c
+fi
+`
+
+func TestPushManifestList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "docker")
+	ioutil.WriteFile(script, []byte(fakeManifest), 0755)
+	os.Setenv("CHEOPS_TEST_DIR", dir)
+	defer os.Unsetenv("CHEOPS_TEST_DIR")
+	// No builders or plugins shared from the config of the user
+	os.Setenv("DOCKER_CONFIG", filepath.Join(dir, "missing"))
+	defer os.Unsetenv("DOCKER_CONFIG")
+
+	engine := &Engine{authKey: ServerAddress, docker: []string{script}}
+	out := bytes.Buffer{}
+	digest, err := engine.PushManifestList(context.Background(), "ghcr.io/octo/app:1.2", []string{
+		"ghcr.io/octo/app@sha256:aaaa",
+		"ghcr.io/octo/app@sha256:bbbb",
+	}, `{"username":"octo","password":"secret"}`, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if digest != "sha256:0f1e2d3c" {
+		t.Error("Wrong digest:", digest)
+	}
+
+	calls, _ := ioutil.ReadFile(filepath.Join(dir, "calls"))
+	expected := `manifest create ghcr.io/octo/app:1.2 ghcr.io/octo/app@sha256:aaaa ghcr.io/octo/app@sha256:bbbb
+config.json
+manifest push --purge ghcr.io/octo/app:1.2
+config.json
+`
+	if string(calls) != expected {
+		t.Errorf("Wrong calls:\n%s", calls)
+	}
+
+	if _, err := (&Engine{}).PushManifestList(context.Background(), "app", nil, "{}", &out); err == nil {
+		t.Error("Expected an error without the Docker CLI")
+	}
+}
@@ -0,0 +1,69 @@
+package docker
+
+import (
+	"cheops/types"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// rootPodmanSocket is the socket of the Podman service of root
+const rootPodmanSocket = "/run/podman/podman.sock"
+
+// NewPodman creates an engine for the Podman service of config, through its
+// Docker compatible API
+func NewPodman(config *types.PodmanConfig) (*Engine, error) {
+	host, err := podmanHost(podmanSocket(config))
+	if err != nil {
+		return nil, err
+	}
+
+	version := config.APIVersion
+	if version == "" {
+		version = client.DefaultVersion
+	}
+
+	cli, err := client.NewClient(host, version, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Podman keys build credentials by registry host, including Docker Hub
+	return &Engine{cli: cli, authKey: func(registry string) string {
+		return registry
+	}}, nil
+}
+
+// podmanSocket returns the socket of config, or the one of the environment
+func podmanSocket(config *types.PodmanConfig) string {
+	if config.Socket != "" {
+		return config.Socket
+	}
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		return host
+	}
+	if os.Geteuid() == 0 {
+		return rootPodmanSocket
+	}
+
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", os.Geteuid())
+	}
+	return filepath.Join(runtimeDir, "podman", "podman.sock")
+}
+
+// podmanHost returns the client host of a socket path or URL
+func podmanHost(socket string) (string, error) {
+	if !strings.Contains(socket, "://") {
+		return "unix://" + socket, nil
+	}
+	if !strings.HasPrefix(socket, "unix://") && !strings.HasPrefix(socket, "tcp://") {
+		return "", errors.New("Unsupported Podman socket: " + socket)
+	}
+	return socket, nil
+}
@@ -0,0 +1,143 @@
+package docker
+
+import (
+	"cheops/types"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPodmanSocket(t *testing.T) {
+	if socket := podmanSocket(&types.PodmanConfig{Socket: "/tmp/podman.sock"}); socket != "/tmp/podman.sock" {
+		t.Error("Wrong configured socket:", socket)
+	}
+
+	os.Setenv("CONTAINER_HOST", "unix:///run/user/1000/podman/podman.sock")
+	defer os.Unsetenv("CONTAINER_HOST")
+	if socket := podmanSocket(&types.PodmanConfig{}); socket != "unix:///run/user/1000/podman/podman.sock" {
+		t.Error("Wrong environment socket:", socket)
+	}
+}
+
+func TestPodmanHost(t *testing.T) {
+	tests := map[string]string{
+		"/run/podman/podman.sock":        "unix:///run/podman/podman.sock",
+		"unix:///run/podman/podman.sock": "unix:///run/podman/podman.sock",
+		"tcp://10.0.0.2:8888":            "tcp://10.0.0.2:8888",
+	}
+	for socket, expected := range tests {
+		if host, err := podmanHost(socket); err != nil || host != expected {
+			t.Errorf("Expected %s for %s, got %s (%v)", expected, socket, host, err)
+		}
+	}
+
+	if _, err := podmanHost("ssh://core@10.0.0.2/run/podman/podman.sock"); err == nil {
+		t.Error("Expected an error for an ssh socket")
+	}
+}
+
+// newPodmanServer serves the responses of Podman's compatibility API on a
+// unix socket
+func newPodmanServer(t *testing.T, handler http.Handler) (string, func()) {
+	dir, err := ioutil.TempDir("", "podman")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "podman.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+
+	return socket, func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPodmanEngine(t *testing.T) {
+	serveFile := func(w http.ResponseWriter, name string) {
+		file, err := os.Open("testdata/" + name)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer file.Close()
+		io.Copy(w, file)
+	}
+
+	var registryConfig map[string]json.RawMessage
+	socket, cleanup := newPodmanServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/build"):
+			config, _ := base64.URLEncoding.DecodeString(r.Header.Get("X-Registry-Config"))
+			json.Unmarshal(config, &registryConfig)
+			io.Copy(ioutil.Discard, r.Body)
+			serveFile(w, "podman-build.jsonl")
+
+		case strings.HasSuffix(r.URL.Path, "/images/ghcr.io/octo/app/push"):
+			// Podman 3 doesn't report the digest
+			w.Write([]byte(`{"status":"Storing signatures\n"}` + "\n"))
+
+		case strings.HasSuffix(r.URL.Path, "/images/ghcr.io/octo/app:latest/json"):
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"Id":"sha256:7f3e","RepoDigests":["docker.io/octo/app@sha256:0000","ghcr.io/octo/app@sha256:1111"]}`))
+
+		default:
+			t.Error("Unexpected request:", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer cleanup()
+
+	engine, err := NewPodman(&types.PodmanConfig{Socket: socket})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contextDir, err := ioutil.TempDir("", "context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(contextDir)
+	ioutil.WriteFile(filepath.Join(contextDir, "Dockerfile"), []byte("FROM alpine:3.10\n"), 0644)
+
+	imageID, err := engine.BuildImage(context.Background(), &types.BuildOptions{
+		ContextDir: contextDir,
+		Dockerfile: "Dockerfile",
+		Tags:       []string{"ghcr.io/octo/app:latest"},
+		Credentials: map[string]string{
+			DockerHub: `{"username":"octo","password":"secret"}`,
+		},
+	}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imageID != "sha256:7f3e1b2a9c8d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f" {
+		t.Error("Wrong image ID:", imageID)
+	}
+	if _, ok := registryConfig[DockerHub]; !ok || len(registryConfig) != 1 {
+		t.Error("Wrong registry config:", registryConfig)
+	}
+
+	digest, err := engine.PushImage(context.Background(), "ghcr.io/octo/app:latest", "{}", ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != "sha256:1111" {
+		t.Error("Wrong digest:", digest)
+	}
+}
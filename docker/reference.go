@@ -0,0 +1,108 @@
+package docker
+
+import "strings"
+
+// DockerHub is the registry host of images without an explicit registry
+const DockerHub = "docker.io"
+
+// RegistryHost returns the registry host of an image reference, following
+// the same rules as the Docker CLI
+func RegistryHost(image string) string {
+	i := strings.IndexRune(image, '/')
+	if i == -1 {
+		return DockerHub
+	}
+
+	host := image[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return DockerHub
+	}
+	if host == "index.docker.io" {
+		return DockerHub
+	}
+	return host
+}
+
+// RepositoryName returns the repository of an image reference, without the
+// registry host, tag and digest
+func RepositoryName(image string) string {
+	name := imageName(image)
+	i := strings.IndexRune(name, '/')
+	if i != -1 && (strings.ContainsAny(name[:i], ".:") || name[:i] == "localhost") {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// FullRepository returns the registry host and repository of an image
+// reference, with the library namespace of official Docker Hub images
+func FullRepository(image string) string {
+	host, name := RegistryHost(image), RepositoryName(image)
+	if host == DockerHub && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	return host + "/" + name
+}
+
+// ImageTag returns the tag of an image reference, latest if it has none
+func ImageTag(image string) string {
+	name := image
+	if i := strings.IndexRune(name, '@'); i != -1 {
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		return name[i+1:]
+	}
+	return "latest"
+}
+
+// imageName returns an image reference without its tag and digest
+func imageName(image string) string {
+	name := image
+	if i := strings.IndexRune(name, '@'); i != -1 {
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	return name
+}
+
+// PlatformTag returns the image reference of a platform of a multi-platform
+// image, tagged <tag>-<os>-<arch>[-<variant>]
+func PlatformTag(image, platform string) string {
+	return imageName(image) + ":" + ImageTag(image) + "-" + strings.Replace(platform, "/", "-", -1)
+}
+
+// DigestReference returns the reference of the manifest of an image by
+// digest
+func DigestReference(image, digest string) string {
+	return imageName(image) + "@" + digest
+}
+
+// dockerHubServer is the server address Docker Hub credentials are keyed by
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// ServerAddress returns the server address the Docker CLI and daemon key the
+// credentials of a registry host by
+func ServerAddress(registry string) string {
+	if registry == DockerHub {
+		return dockerHubServer
+	}
+	return registry
+}
+
+// ServerHost returns the registry host of a server address, which may
+// include a scheme and a path
+func ServerHost(server string) string {
+	host := server
+	if i := strings.Index(host, "://"); i != -1 {
+		host = host[i+3:]
+	}
+	host = strings.SplitN(host, "/", 2)[0]
+
+	if host == "index.docker.io" || host == "registry-1.docker.io" {
+		return DockerHub
+	}
+	return host
+}
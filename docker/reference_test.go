@@ -0,0 +1,92 @@
+package docker
+
+import "testing"
+
+func TestRepositoryName(t *testing.T) {
+	tests := map[string]string{
+		"alpine:3.10":                                    "alpine",
+		"localhost:5000/team/app":                        "team/app",
+		"localhost:5000/team/app:v1":                     "team/app",
+		"ghcr.io/octo/app@sha256:abc":                    "octo/app",
+		"ghcr.io/octo/app:v1@sha256:abc":                 "octo/app",
+		"123.dkr.ecr.eu-west-1.amazonaws.com/app:latest": "app",
+	}
+
+	for image, expected := range tests {
+		if name := RepositoryName(image); name != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, image, name)
+		}
+	}
+}
+
+func TestFullRepository(t *testing.T) {
+	tests := map[string]string{
+		"alpine:3.10":                       "docker.io/library/alpine",
+		"docker.io/library/alpine@sha256:a": "docker.io/library/alpine",
+		"octo/app":                          "docker.io/octo/app",
+		"localhost/app:v1":                  "localhost/app",
+		"ghcr.io/octo/app:v1":               "ghcr.io/octo/app",
+	}
+
+	for image, expected := range tests {
+		if repository := FullRepository(image); repository != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, image, repository)
+		}
+	}
+}
+
+func TestPlatformTag(t *testing.T) {
+	tests := map[string]string{
+		"alpine":                       "alpine:latest-linux-arm64",
+		"localhost:5000/app:v1":        "localhost:5000/app:v1-linux-arm64",
+		"ghcr.io/octo/app:v1@sha256:a": "ghcr.io/octo/app:v1-linux-arm64",
+	}
+
+	for image, expected := range tests {
+		if tag := PlatformTag(image, "linux/arm64"); tag != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, image, tag)
+		}
+	}
+
+	if tag := PlatformTag("app:v1", "linux/arm/v7"); tag != "app:v1-linux-arm-v7" {
+		t.Error("Wrong variant tag:", tag)
+	}
+	if ref := DigestReference("localhost:5000/app:v1", "sha256:abc"); ref != "localhost:5000/app@sha256:abc" {
+		t.Error("Wrong digest reference:", ref)
+	}
+}
+
+func TestImageTag(t *testing.T) {
+	tests := map[string]string{
+		"alpine":                       "latest",
+		"alpine:3.10":                  "3.10",
+		"localhost:5000/app":           "latest",
+		"localhost:5000/app:v1":        "v1",
+		"ghcr.io/octo/app:v1@sha256:a": "v1",
+	}
+
+	for image, expected := range tests {
+		if tag := ImageTag(image); tag != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, image, tag)
+		}
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	tests := map[string]string{
+		"alpine":                        DockerHub,
+		"alpine:3.10":                   DockerHub,
+		"library/alpine":                DockerHub,
+		"index.docker.io/library/nginx": DockerHub,
+		"ghcr.io/octo/app:latest":       "ghcr.io",
+		"localhost/app":                 "localhost",
+		"localhost:5000/app":            "localhost:5000",
+		"123456789012.dkr.ecr.eu-west-1.amazonaws.com/app@sha256:abc": "123456789012.dkr.ecr.eu-west-1.amazonaws.com",
+	}
+
+	for image, expected := range tests {
+		if host := RegistryHost(image); host != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, image, host)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package docker
+
+import (
+	"cheops/types"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newFakeDaemon serves a container run whose commands exit with status and
+// records the requests
+func newFakeDaemon(t *testing.T, status int) (*Engine, *[]string, func()) {
+	mutex := sync.Mutex{}
+	requests := []string{}
+
+	socket, cleanup := newPodmanServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path[strings.Index(r.URL.Path[1:], "/")+1:]
+		mutex.Lock()
+		requests = append(requests, r.Method+" "+path)
+		mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case path == "/images/alpine/json":
+			w.Write([]byte(`{"Id":"sha256:965e"}`))
+
+		case path == "/containers/create":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id":"c0ffee"}`))
+
+		case path == "/containers/c0ffee/logs":
+			// A single stdout frame
+			line := []byte("running tests\n")
+			header := []byte{1, 0, 0, 0, 0, 0, 0, 0}
+			binary.BigEndian.PutUint32(header[4:], uint32(len(line)))
+			w.Write(append(header, line...))
+
+		case path == "/containers/c0ffee/wait":
+			w.Write([]byte(`{"StatusCode":` + strconv.Itoa(status) + `}`))
+
+		case path == "/containers/c0ffee/archive":
+			w.Header().Set("X-Docker-Container-Path-Stat", "e30=")
+			w.Header().Set("Content-Type", "application/x-tar")
+
+		case strings.HasPrefix(path, "/containers/c0ffee"):
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			t.Error("Unexpected request:", r.Method, path)
+			http.NotFound(w, r)
+		}
+	}))
+
+	engine, err := New(&types.DockerConfig{Host: "unix://" + socket})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return engine, &requests, cleanup
+}
+
+func TestRunContainer(t *testing.T) {
+	engine, requests, cleanup := newFakeDaemon(t, 0)
+	defer cleanup()
+
+	copied := []string{}
+	out := strings.Builder{}
+	err := engine.RunContainer(context.Background(), &types.RunOptions{
+		Image:     "alpine",
+		Commands:  []string{"make test"},
+		Artifacts: []string{"/src/report.xml"},
+		CopyArtifact: func(path string, archive io.Reader) error {
+			copied = append(copied, path)
+			return nil
+		},
+	}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != "running tests\n" {
+		t.Error("Wrong output:", out.String())
+	}
+	if len(copied) != 1 || copied[0] != "/src/report.xml" {
+		t.Error("Wrong artifacts:", copied)
+	}
+	if last := (*requests)[len(*requests)-1]; last != "DELETE /containers/c0ffee" {
+		t.Error("Container not removed:", *requests)
+	}
+}
+
+func TestRunContainerFailure(t *testing.T) {
+	engine, requests, cleanup := newFakeDaemon(t, 2)
+	defer cleanup()
+
+	err := engine.RunContainer(context.Background(), &types.RunOptions{
+		Image:     "alpine",
+		Commands:  []string{"false"},
+		Artifacts: []string{"/src/report.xml"},
+		CopyArtifact: func(path string, archive io.Reader) error {
+			t.Error("Artifact copied after failed commands:", path)
+			return nil
+		},
+	}, ioutil.Discard)
+	if err == nil || err.Error() != "Commands exited with status 2" {
+		t.Error("Wrong error:", err)
+	}
+	if last := (*requests)[len(*requests)-1]; last != "DELETE /containers/c0ffee" {
+		t.Error("Container not removed:", *requests)
+	}
+}
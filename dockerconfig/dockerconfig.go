@@ -0,0 +1,179 @@
+package dockerconfig
+
+import (
+	"bytes"
+	"cheops/docker"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// tokenUsername is the username credential helpers return for identity
+// tokens
+const tokenUsername = "<token>"
+
+type configFile struct {
+	Auths       map[string]types.AuthConfig `json:"auths"`
+	CredsStore  string                      `json:"credsStore"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+}
+
+type helperCredentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// DockerConfigCredentialsProvider reads the credentials the Docker CLI
+// stores after a docker login, either in the config file itself or through
+// a docker-credential-* helper
+type DockerConfigCredentialsProvider struct {
+	path string
+}
+
+// DefaultPath returns the config file the Docker CLI uses
+func DefaultPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+func New(path string) (*DockerConfigCredentialsProvider, error) {
+	if path == "" {
+		path = DefaultPath()
+	}
+
+	log.WithFields(log.Fields{
+		"provider": "dockerconfig",
+		"path":     path,
+	}).Debug("Initializing Docker credentials provider")
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	return &DockerConfigCredentialsProvider{path}, nil
+}
+
+// GetCredentials is called on every push, so credentials refreshed by a new
+// docker login are picked up
+func (p *DockerConfigCredentialsProvider) GetCredentials(registry string) (string, error) {
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return "", err
+	}
+
+	config := configFile{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", err
+	}
+
+	server := docker.ServerAddress(registry)
+	var auth *types.AuthConfig
+
+	// Same precedence as the Docker CLI: a helper for the registry, then the
+	// default store, then the config file
+	if helper, ok := config.CredHelpers[registry]; ok {
+		auth, err = getFromHelper(helper, server)
+	} else if config.CredsStore != "" {
+		auth, err = getFromHelper(config.CredsStore, server)
+	} else {
+		auth, err = getFromAuths(config.Auths, registry)
+	}
+	if err != nil {
+		return "", err
+	}
+	if auth == nil {
+		return "", errors.New("No credentials for registry: " + registry)
+	}
+
+	auth.ServerAddress = server
+	bytes, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes), nil
+}
+
+func getFromAuths(auths map[string]types.AuthConfig, registry string) (*types.AuthConfig, error) {
+	for server, entry := range auths {
+		if docker.ServerHost(server) != registry {
+			continue
+		}
+
+		auth := entry
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, err
+			}
+
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return nil, errors.New("Invalid auth for registry: " + server)
+			}
+			auth.Username = parts[0]
+			auth.Password = parts[1]
+			auth.Auth = ""
+		}
+		return &auth, nil
+	}
+
+	return nil, nil
+}
+
+// getFromHelper runs the get command of a credential helper, it returns
+// nil if the helper has no credentials for the server
+func getFromHelper(helper, server string) (*types.AuthConfig, error) {
+	log.WithFields(log.Fields{
+		"helper": helper,
+		"server": server,
+	}).Debug("Running Docker credential helper")
+
+	stdout := bytes.Buffer{}
+	stderr := bytes.Buffer{}
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		// Helpers print the error on stdout
+		message := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(message, "credentials not found") {
+			return nil, nil
+		}
+		if message != "" {
+			return nil, errors.New("Credential helper " + helper + " failed: " + message)
+		}
+		return nil, err
+	}
+
+	creds := helperCredentials{}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return nil, err
+	}
+
+	if creds.Username == tokenUsername {
+		return &types.AuthConfig{IdentityToken: creds.Secret}, nil
+	}
+	return &types.AuthConfig{
+		Username: creds.Username,
+		Password: creds.Secret,
+	}, nil
+}
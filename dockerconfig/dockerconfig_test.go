@@ -0,0 +1,110 @@
+package dockerconfig
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testConfig = `{
+  "auths": {
+    "https://index.docker.io/v1/": {"auth": "aHViOmh1YnBhc3M="},
+    "localhost:5000": {"username": "local", "password": "localpass"},
+    "ghcr.io": {}
+  },
+  "credHelpers": {
+    "ghcr.io": "fake",
+    "quay.io": "fake"
+  }
+}`
+
+// fakeHelper answers with a token for ghcr.io and has no credentials for
+// any other server
+const fakeHelper = `#!/bin/sh
+read server
+if [ "$server" = "ghcr.io" ]; then
+  echo '{"ServerURL":"ghcr.io","Username":"<token>","Secret":"ghcr-token"}'
+else
+  echo "credentials not found in native keychain"
+  exit 1
+fi
+`
+
+func setup(t *testing.T) (*DockerConfigCredentialsProvider, func()) {
+	dir, err := ioutil.TempDir("", "cheops-dockerconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(testConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "docker-credential-fake"), []byte(fakeHelper), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	oldPath := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+oldPath)
+
+	p, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p, func() {
+		os.Setenv("PATH", oldPath)
+		os.RemoveAll(dir)
+	}
+}
+
+func getAuth(t *testing.T, p *DockerConfigCredentialsProvider, registry string) map[string]string {
+	creds, err := p.GetCredentials(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := map[string]string{}
+	if err := json.Unmarshal([]byte(creds), &auth); err != nil {
+		t.Fatal(err)
+	}
+	return auth
+}
+
+func TestAuths(t *testing.T) {
+	p, cleanup := setup(t)
+	defer cleanup()
+
+	auth := getAuth(t, p, "docker.io")
+	if auth["username"] != "hub" || auth["password"] != "hubpass" || auth["serveraddress"] != "https://index.docker.io/v1/" {
+		t.Error("Wrong Docker Hub credentials:", auth)
+	}
+	if _, ok := auth["auth"]; ok {
+		t.Error("Encoded auth should be decoded:", auth)
+	}
+
+	auth = getAuth(t, p, "localhost:5000")
+	if auth["username"] != "local" || auth["password"] != "localpass" || auth["serveraddress"] != "localhost:5000" {
+		t.Error("Wrong local registry credentials:", auth)
+	}
+
+	if _, err := p.GetCredentials("registry.example.com"); err == nil {
+		t.Error("Expected an error for an unknown registry")
+	}
+}
+
+func TestCredHelpers(t *testing.T) {
+	p, cleanup := setup(t)
+	defer cleanup()
+
+	auth := getAuth(t, p, "ghcr.io")
+	if auth["identitytoken"] != "ghcr-token" || auth["serveraddress"] != "ghcr.io" {
+		t.Error("Wrong helper credentials:", auth)
+	}
+
+	if _, err := p.GetCredentials("quay.io"); err == nil {
+		t.Error("Expected an error when the helper has no credentials")
+	}
+}
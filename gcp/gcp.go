@@ -0,0 +1,248 @@
+package gcp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultTokenURL is the Google OAuth token endpoint
+const DefaultTokenURL = "https://oauth2.googleapis.com/token"
+
+const (
+	scope = "https://www.googleapis.com/auth/cloud-platform"
+	// username is the user registries expect with an OAuth access token
+	username = "oauth2accesstoken"
+	// expiryMargin is how long before its expiry a token is renewed
+	expiryMargin = 5 * time.Minute
+	// assertionLifetime is the validity of the JWT sent to the token endpoint
+	assertionLifetime = time.Hour
+)
+
+type serviceAccountKey struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// GCPDockerCredentialsProvider exchanges a service account key for OAuth
+// access tokens accepted by Artifact Registry and Container Registry
+type GCPDockerCredentialsProvider struct {
+	email    string
+	keyID    string
+	key      *rsa.PrivateKey
+	tokenURL string
+	client   *http.Client
+
+	mutex   sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// New creates a provider from the JSON key of a service account. tokenURL
+// overrides the token endpoint of the key.
+func New(keyJSON, tokenURL string) (*GCPDockerCredentialsProvider, error) {
+	log.WithFields(log.Fields{
+		"provider": "gcp",
+	}).Debug("Initializing Docker credentials provider")
+
+	if keyJSON == "" {
+		return nil, errors.New("Must specify a service account key")
+	}
+
+	account := serviceAccountKey{}
+	if err := json.Unmarshal([]byte(keyJSON), &account); err != nil {
+		return nil, err
+	}
+	if account.Type != "service_account" || account.ClientEmail == "" {
+		return nil, errors.New("Not a service account key")
+	}
+
+	key, err := parsePrivateKey(account.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokenURL == "" {
+		tokenURL = account.TokenURI
+	}
+	if tokenURL == "" {
+		tokenURL = DefaultTokenURL
+	}
+
+	return &GCPDockerCredentialsProvider{
+		email:    account.ClientEmail,
+		keyID:    account.PrivateKeyID,
+		key:      key,
+		tokenURL: tokenURL,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func parsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("Invalid service account private key")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("The service account private key must be an RSA key")
+	}
+	return key, nil
+}
+
+func encodeSegment(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// assertion returns the signed JWT requesting an access token
+func (p *GCPDockerCredentialsProvider) assertion(now time.Time) (string, error) {
+	header, err := encodeSegment(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"kid": p.keyID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := encodeSegment(map[string]interface{}{
+		"iss":   p.email,
+		"scope": scope,
+		"aud":   p.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(assertionLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := header + "." + claims
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (p *GCPDockerCredentialsProvider) fetchToken() (string, time.Time, error) {
+	now := time.Now()
+	assertion, err := p.assertion(now)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	log.WithFields(log.Fields{
+		"account": p.email,
+	}).Debug("Requesting GCP access token")
+
+	res, err := p.client.PostForm(p.tokenURL, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer res.Body.Close()
+
+	token := tokenResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", time.Time{}, errors.New("Can't get GCP access token: " + res.Status)
+	}
+	if res.StatusCode != http.StatusOK || token.AccessToken == "" {
+		message := strings.TrimSpace(token.Error + " " + token.Description)
+		if message == "" {
+			message = res.Status
+		}
+		return "", time.Time{}, errors.New("Can't get GCP access token: " + message)
+	}
+
+	return token.AccessToken, now.Add(time.Duration(token.ExpiresIn) * time.Second), nil
+}
+
+// accessToken returns the cached token, or a new one if it's about to
+// expire
+func (p *GCPDockerCredentialsProvider) accessToken() (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.token != "" && time.Now().Add(expiryMargin).Before(p.expires) {
+		return p.token, nil
+	}
+
+	token, expires, err := p.fetchToken()
+	if err != nil {
+		return "", err
+	}
+
+	p.token = token
+	p.expires = expires
+	return token, nil
+}
+
+// isGoogleRegistry reports whether registry is a Container Registry or
+// Artifact Registry host
+func isGoogleRegistry(registry string) bool {
+	return registry == "gcr.io" || strings.HasSuffix(registry, ".gcr.io") ||
+		strings.HasSuffix(registry, ".pkg.dev")
+}
+
+func (p *GCPDockerCredentialsProvider) GetCredentials(registry string) (string, error) {
+	if !isGoogleRegistry(registry) {
+		return "", errors.New("Not a Google registry: " + registry)
+	}
+
+	token, err := p.accessToken()
+	if err != nil {
+		return "", err
+	}
+
+	bytes, err := json.Marshal(types.AuthConfig{
+		Username:      username,
+		Password:      token,
+		ServerAddress: registry,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes), nil
+}
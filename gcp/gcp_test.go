@@ -0,0 +1,151 @@
+package gcp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type tokenServer struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	requests int
+	// expiresIn is the lifetime of the returned tokens in seconds
+	expiresIn int
+}
+
+func newTokenServer(t *testing.T) *tokenServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &tokenServer{key: key, expiresIn: 3600}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests++
+
+		if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			t.Error("Wrong grant type:", r.FormValue("grant_type"))
+		}
+
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		if len(parts) != 3 {
+			t.Error("Invalid assertion:", r.FormValue("assertion"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant","error_description":"Invalid JWT signature."}`)
+			return
+		}
+
+		claims := map[string]interface{}{}
+		data, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		json.Unmarshal(data, &claims)
+		if claims["iss"] != "pusher@project.iam.gserviceaccount.com" || claims["aud"] != s.URL+"/token" {
+			t.Error("Wrong claims:", claims)
+		}
+
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":%d,"token_type":"Bearer"}`, s.requests, s.expiresIn)
+	}))
+	return s
+}
+
+func (s *tokenServer) keyJSON(key *rsa.PrivateKey) string {
+	data, _ := x509.MarshalPKCS8PrivateKey(key)
+	block := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: data})
+
+	account, _ := json.Marshal(serviceAccountKey{
+		Type:         "service_account",
+		ClientEmail:  "pusher@project.iam.gserviceaccount.com",
+		PrivateKeyID: "1234",
+		PrivateKey:   string(block),
+		TokenURI:     DefaultTokenURL,
+	})
+	return string(account)
+}
+
+func TestGetCredentials(t *testing.T) {
+	server := newTokenServer(t)
+	defer server.Close()
+
+	p, err := New(server.keyJSON(server.key), server.URL+"/token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		creds, err := p.GetCredentials("europe-docker.pkg.dev")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if creds != `{"username":"oauth2accesstoken","password":"token-1","serveraddress":"europe-docker.pkg.dev"}` {
+			t.Error("Wrong credentials:", creds)
+		}
+	}
+	if server.requests != 1 {
+		t.Error("Expected the token to be cached, got", server.requests, "requests")
+	}
+}
+
+func TestTokenRenewal(t *testing.T) {
+	server := newTokenServer(t)
+	defer server.Close()
+	// Tokens expiring within the margin are renewed on every call
+	server.expiresIn = 60
+
+	p, err := New(server.keyJSON(server.key), server.URL+"/token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.GetCredentials("gcr.io")
+	creds, err := p.GetCredentials("gcr.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(creds, `"password":"token-2"`) {
+		t.Error("Expected a renewed token:", creds)
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	server := newTokenServer(t)
+	defer server.Close()
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(server.keyJSON(other), server.URL+"/token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.GetCredentials("gcr.io")
+	if err == nil || !strings.Contains(err.Error(), "Invalid JWT signature") {
+		t.Error("Expected the token endpoint error, got", err)
+	}
+
+	if _, err := p.GetCredentials("docker.io"); err == nil {
+		t.Error("Expected an error for a non Google registry")
+	}
+
+	if _, err := New(`{"type":"authorized_user"}`, ""); err == nil {
+		t.Error("Expected an error for a non service account key")
+	}
+}
@@ -0,0 +1,75 @@
+package git
+
+import (
+	"cheops/types"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/utils/merkletrie"
+)
+
+// ReadCommit returns the details of a commit of a cloned repository, with
+// the files it changed compared to its first parent
+func ReadCommit(repoDir, hash string) (*types.Commit, error) {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := repo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &types.Commit{
+		ID:      commit.Hash.String(),
+		Message: commit.Message,
+		Author: &types.Person{
+			Name:  commit.Author.Name,
+			Email: commit.Author.Email,
+		},
+		Timestamp: commit.Author.When,
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	// The root commit is compared to an empty tree
+	parentTree := &object.Tree{}
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		parentTree, err = parent.Tree()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, change := range changes {
+		action, err := change.Action()
+		if err != nil {
+			return nil, err
+		}
+
+		switch action {
+		case merkletrie.Insert:
+			result.Added = append(result.Added, change.To.Name)
+		case merkletrie.Delete:
+			result.Removed = append(result.Removed, change.From.Name)
+		case merkletrie.Modify:
+			result.Modified = append(result.Modified, change.To.Name)
+		}
+	}
+
+	return result, nil
+}
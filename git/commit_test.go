@@ -0,0 +1,90 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func TestReadCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cheops-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	when := time.Date(2019, 11, 20, 10, 30, 0, 0, time.UTC)
+	commit := func(message string) string {
+		if _, err := tree.Add("."); err != nil {
+			t.Fatal(err)
+		}
+		// Add doesn't stage deletions
+		status, _ := tree.Status()
+		for file, s := range status {
+			if s.Worktree == git.Deleted {
+				tree.Remove(file)
+			}
+		}
+
+		hash, err := tree.Commit(message, &git.CommitOptions{
+			Author: &object.Signature{Name: "Octo Cat", Email: "octocat@example.com", When: when},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash.String()
+	}
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("README.md", "readme")
+	write("OLD.md", "old")
+	first := commit("Initial commit")
+
+	write("README.md", "updated readme")
+	write("login.html", "<form>")
+	os.Remove(filepath.Join(dir, "OLD.md"))
+	second := commit("Add login form\n")
+
+	info, err := ReadCommit(dir, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(info.Added, []string{"OLD.md", "README.md"}) {
+		t.Error("Wrong added files:", info.Added)
+	}
+
+	info, err = ReadCommit(dir, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != second || info.Message != "Add login form\n" {
+		t.Error("Wrong commit:", info.ID, info.Message)
+	}
+	if info.Author.Name != "Octo Cat" || info.Author.Email != "octocat@example.com" || !info.Timestamp.Equal(when) {
+		t.Error("Wrong author:", info.Author, info.Timestamp)
+	}
+	if !reflect.DeepEqual(info.Added, []string{"login.html"}) ||
+		!reflect.DeepEqual(info.Removed, []string{"OLD.md"}) ||
+		!reflect.DeepEqual(info.Modified, []string{"README.md"}) {
+		t.Error("Wrong changed files:", info.Added, info.Removed, info.Modified)
+	}
+}
@@ -1,11 +1,15 @@
 package git
 
 import (
-	"os"
+	"errors"
+	"io"
 
 	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
 )
 
 func checkoutToCommit(repo *git.Repository, commit string) error {
@@ -24,10 +28,10 @@ func checkoutToCommit(repo *git.Repository, commit string) error {
 	return nil
 }
 
-func CloneRepo(repoURL, commit, targetDir string) error {
+func CloneRepo(repoURL, commit, targetDir string, progress io.Writer) error {
 	repo, err := git.PlainClone(targetDir, false, &git.CloneOptions{
 		URL:      repoURL,
-		Progress: os.Stdout,
+		Progress: progress,
 	})
 	if err != nil {
 		return err
@@ -36,10 +40,10 @@ func CloneRepo(repoURL, commit, targetDir string) error {
 	return checkoutToCommit(repo, commit)
 }
 
-func CloneRepoWithToken(repoURL, token, commit, targetDir string) error {
+func CloneRepoWithToken(repoURL, token, commit, targetDir string, progress io.Writer) error {
 	repo, err := git.PlainClone(targetDir, false, &git.CloneOptions{
 		URL:      repoURL,
-		Progress: os.Stdout,
+		Progress: progress,
 		Auth: &http.BasicAuth{
 			Username: "token",
 			Password: token,
@@ -51,3 +55,37 @@ func CloneRepoWithToken(repoURL, token, commit, targetDir string) error {
 
 	return checkoutToCommit(repo, commit)
 }
+
+func resolveBranch(repoURL, branch string, auth transport.AuthMethod) (string, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{repoURL},
+	})
+
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return "", err
+	}
+
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	for _, ref := range refs {
+		if ref.Name() == branchRef {
+			return ref.Hash().String(), nil
+		}
+	}
+
+	return "", errors.New("Branch not found: " + branch)
+}
+
+// ResolveBranch returns the commit the branch head points to
+func ResolveBranch(repoURL, branch string) (string, error) {
+	return resolveBranch(repoURL, branch, nil)
+}
+
+// ResolveBranchWithToken returns the commit the branch head points to
+func ResolveBranchWithToken(repoURL, token, branch string) (string, error) {
+	return resolveBranch(repoURL, branch, &http.BasicAuth{
+		Username: "token",
+		Password: token,
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -21,14 +22,86 @@ type GithubGitProvider struct {
 	name     string
 }
 
+type githubPerson struct {
+	Name     string
+	Email    string
+	Username string
+}
+
+type githubCommit struct {
+	Id        string
+	Message   string
+	Timestamp time.Time
+	URL       string
+	Author    githubPerson
+	Added     []string
+	Removed   []string
+	Modified  []string
+}
+
 type githubPayload struct {
 	Ref        string
+	Compare    string
 	Repository struct {
 		URL string
 	}
-	HeadCommit struct {
-		Id string
-	} `json:"head_commit"`
+	Pusher     githubPerson
+	HeadCommit githubCommit `json:"head_commit"`
+	Commits    []githubCommit
+}
+
+func (p *githubPerson) person() *types.Person {
+	return &types.Person{
+		Name:     p.Name,
+		Email:    p.Email,
+		Username: p.Username,
+	}
+}
+
+func (c *githubCommit) commit() *types.Commit {
+	return &types.Commit{
+		ID:        c.Id,
+		Message:   c.Message,
+		Author:    c.Author.person(),
+		Timestamp: c.Timestamp,
+		URL:       c.URL,
+		Added:     c.Added,
+		Removed:   c.Removed,
+		Modified:  c.Modified,
+	}
+}
+
+// parsePush extracts the commit information from a push event payload
+func parsePush(data []byte) (*types.CommitInfo, error) {
+	var payload githubPayload
+	err := json.Unmarshal(data, &payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var branch string
+	refParts := strings.Split(payload.Ref, "/")
+	if len(refParts) >= 3 && refParts[1] == "heads" {
+		branch = strings.Join(refParts[2:], "/")
+	} else {
+		return nil, errors.New("Not a branch commit")
+	}
+
+	info := types.CommitInfo{
+		ID:         payload.HeadCommit.Id,
+		RepoURL:    payload.Repository.URL,
+		Branch:     branch,
+		Message:    payload.HeadCommit.Message,
+		Author:     payload.HeadCommit.Author.person(),
+		Pusher:     payload.Pusher.person(),
+		CompareURL: payload.Compare,
+		Timestamp:  payload.HeadCommit.Timestamp,
+	}
+	for i := range payload.Commits {
+		info.Commits = append(info.Commits, payload.Commits[i].commit())
+	}
+
+	return &info, nil
 }
 
 func New(cheops types.Cheops, providerConfig *types.GitProviderConfig) (*GithubGitProvider, error) {
@@ -69,8 +142,7 @@ func New(cheops types.Cheops, providerConfig *types.GitProviderConfig) (*GithubG
 			return nil, errors.New("Not a push event")
 		}
 
-		var payload githubPayload
-		err = json.Unmarshal(data, &payload)
+		info, err := parsePush(data)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"provider": providerConfig.Name,
@@ -79,34 +151,24 @@ func New(cheops types.Cheops, providerConfig *types.GitProviderConfig) (*GithubG
 			return nil, err
 		}
 
-		var branch string
-		refParts := strings.Split(payload.Ref, "/")
-		if refParts[1] == "heads" {
-			branch = refParts[2]
-		} else {
-			return nil, errors.New("Not a branch commit")
-		}
-
-		info := types.CommitInfo{
-			ID:      payload.HeadCommit.Id,
-			RepoURL: payload.Repository.URL,
-			Branch:  branch,
-		}
-
-		return &info, nil
+		return info, nil
 	})
 
 	return &p, nil
 }
 
-func (p *GithubGitProvider) Clone(commit *types.CommitInfo, targetDir string) error {
-	err := git.CloneRepoWithToken(commit.RepoURL, p.token, commit.ID, targetDir)
+func (p *GithubGitProvider) Clone(commit *types.CommitInfo, targetDir string, progress io.Writer) error {
+	err := git.CloneRepoWithToken(commit.RepoURL, p.token, commit.ID, targetDir, progress)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func (p *GithubGitProvider) ResolveBranch(repoURL, branch string) (string, error) {
+	return git.ResolveBranchWithToken(repoURL, p.token, branch)
+}
+
 func (p *GithubGitProvider) RegisterRepo(repo *types.Repository) error {
 	if !strings.HasPrefix(repo.URL, "https://github.com/") {
 		return errors.New("The repository URL must start with https://github.com/")
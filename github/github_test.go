@@ -0,0 +1,81 @@
+package github
+
+import (
+	"testing"
+)
+
+const pushPayload = `{
+  "ref": "refs/heads/feature/login",
+  "compare": "https://github.com/octo/app/compare/1111...2222",
+  "repository": {"url": "https://github.com/octo/app"},
+  "pusher": {"name": "octocat", "email": "octocat@example.com"},
+  "head_commit": {
+    "id": "2222",
+    "message": "Add login form",
+    "timestamp": "2019-11-20T10:30:00+01:00",
+    "author": {"name": "Octo Cat", "email": "octocat@example.com", "username": "octocat"},
+    "added": ["login.html"],
+    "removed": [],
+    "modified": ["index.html"]
+  },
+  "commits": [
+    {
+      "id": "1111",
+      "message": "Update docs",
+      "timestamp": "2019-11-20T10:00:00+01:00",
+      "author": {"name": "Octo Cat", "email": "octocat@example.com", "username": "octocat"},
+      "added": [],
+      "removed": ["OLD.md"],
+      "modified": ["README.md"]
+    },
+    {
+      "id": "2222",
+      "message": "Add login form",
+      "timestamp": "2019-11-20T10:30:00+01:00",
+      "author": {"name": "Octo Cat", "email": "octocat@example.com", "username": "octocat"},
+      "added": ["login.html"],
+      "removed": [],
+      "modified": ["index.html"]
+    }
+  ]
+}`
+
+func TestParsePush(t *testing.T) {
+	info, err := parsePush([]byte(pushPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.ID != "2222" || info.Branch != "feature/login" || info.RepoURL != "https://github.com/octo/app" {
+		t.Error("Wrong commit:", info.ID, info.Branch, info.RepoURL)
+	}
+	if info.Message != "Add login form" {
+		t.Error("Wrong message:", info.Message)
+	}
+	if info.Author.Name != "Octo Cat" || info.Author.Username != "octocat" {
+		t.Error("Wrong author:", info.Author)
+	}
+	if info.Pusher.Name != "octocat" {
+		t.Error("Wrong pusher:", info.Pusher)
+	}
+	if info.CompareURL != "https://github.com/octo/app/compare/1111...2222" {
+		t.Error("Wrong compare URL:", info.CompareURL)
+	}
+	if info.Timestamp.Hour() != 10 || info.Timestamp.Minute() != 30 {
+		t.Error("Wrong timestamp:", info.Timestamp)
+	}
+
+	if len(info.Commits) != 2 {
+		t.Fatal("Expected 2 commits, got", len(info.Commits))
+	}
+	if info.Commits[0].Removed[0] != "OLD.md" || info.Commits[1].Added[0] != "login.html" {
+		t.Error("Wrong changed files:", info.Commits[0], info.Commits[1])
+	}
+}
+
+func TestParsePushTag(t *testing.T) {
+	_, err := parsePush([]byte(`{"ref": "refs/tags/v1.0.0"}`))
+	if err == nil {
+		t.Error("Expected an error for a tag push")
+	}
+}
@@ -0,0 +1,203 @@
+package notify
+
+import (
+	"bytes"
+	"cheops/types"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultSubject = `[cheops] {{.Build.RepoURL}} ({{.Build.Branch}}) ` +
+	`{{if eq .Event "start"}}started{{else}}{{.Build.Status}}{{end}}`
+
+const defaultBody = `Build {{.Build.ID}} of {{.Build.RepoURL}} {{if eq .Event "start"}}started{{else}}{{.Build.Status}}{{end}}.
+
+Branch: {{.Build.Branch}}
+Commit: {{.Build.Commit.ID}}
+{{- with .Build.Commit.Author}}
+Author: {{.Name}}{{if .Email}} <{{.Email}}>{{end}}{{end}}
+{{- with .Build.Commit.CompareURL}}
+Changes: {{.}}{{end}}
+{{- with .Build.Commit.Message}}
+
+{{.}}{{end}}
+{{- range .Build.Steps}}{{if eq .Status "failed"}}
+Failed step: {{.Name}}{{end}}{{end}}
+{{- if .Build.Error}}
+Error: {{.Build.Error}}{{end}}
+{{- if .URL}}
+
+{{.URL}}{{end}}
+{{- if .LogTail}}
+
+Last lines of the build log:
+
+{{range .LogTail}}{{.}}
+{{end}}{{end}}
+`
+
+// EmailNotifier sends build events by email through an SMTP server
+type EmailNotifier struct {
+	server  *types.SMTPConfig
+	to      []string
+	subject *template.Template
+	body    *template.Template
+}
+
+func findServer(name string, servers []*types.SMTPConfig) (*types.SMTPConfig, error) {
+	if name == "" {
+		if len(servers) != 1 {
+			return nil, errors.New("Email notifier must name its SMTP provider")
+		}
+		return servers[0], nil
+	}
+
+	for _, server := range servers {
+		if server.Name == name {
+			return server, nil
+		}
+	}
+	return nil, errors.New("Unknown SMTP provider: " + name)
+}
+
+// smtpTimeout bounds the whole SMTP session, so that a stalled server can't
+// block the build
+var smtpTimeout = time.Minute
+
+func newEmail(config *types.NotifierConfig, servers []*types.SMTPConfig) (*EmailNotifier, error) {
+	if len(config.To) == 0 {
+		return nil, errors.New("Email notifier needs recipients")
+	}
+
+	server, err := findServer(config.Provider, servers)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err := parseTemplate("subject", config.Subject, defaultSubject)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := parseTemplate("body", config.Message, defaultBody)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EmailNotifier{
+		server:  server,
+		to:      config.To,
+		subject: subject,
+		body:    body,
+	}, nil
+}
+
+// encodeHeader turns a rendered template into a header value, on a single
+// line and encoded if it isn't plain ASCII
+func encodeHeader(value string) string {
+	value = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(value)
+	return mime.QEncoding.Encode("utf-8", value)
+}
+
+func (e *EmailNotifier) message(event *types.BuildEvent) ([]byte, error) {
+	subject, err := render(e.subject, event)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := render(e.body, event)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := bytes.Buffer{}
+	fmt.Fprintf(&msg, "From: %s\r\n", e.server.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", encodeHeader(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	return msg.Bytes(), nil
+}
+
+func (e *EmailNotifier) Notify(event *types.BuildEvent) error {
+	msg, err := e.message(event)
+	if err != nil {
+		return err
+	}
+
+	port := e.server.Port
+	if port == 0 {
+		port = 25
+	}
+	addr := net.JoinHostPort(e.server.Host, strconv.Itoa(port))
+
+	log.WithFields(log.Fields{
+		"server": addr,
+		"to":     e.to,
+	}).Debug("Sending email notification")
+
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(smtpTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, e.server.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if e.server.StartTLS {
+		if err := c.StartTLS(&tls.Config{ServerName: e.server.Host}); err != nil {
+			return err
+		}
+	}
+
+	if e.server.Username != "" {
+		auth := smtp.PlainAuth("", e.server.Username, e.server.Password, e.server.Host)
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(e.server.From); err != nil {
+		return err
+	}
+	for _, to := range e.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,236 @@
+package notify
+
+import (
+	"bufio"
+	"cheops/types"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mail struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// startSMTPServer runs a minimal SMTP server accepting a single message
+func startSMTPServer(t *testing.T) (*types.SMTPConfig, chan *mail) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mails := make(chan *mail, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		m := &mail{}
+		reader := bufio.NewReader(conn)
+		reply := func(line string) {
+			conn.Write([]byte(line + "\r\n"))
+		}
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+			switch command {
+			case "EHLO":
+				reply("250-localhost")
+				reply("250 AUTH PLAIN")
+			case "AUTH":
+				m.auth = line
+				reply("235 Authenticated")
+			case "MAIL":
+				m.from = line
+				reply("250 OK")
+			case "RCPT":
+				m.to = append(m.to, line)
+				reply("250 OK")
+			case "DATA":
+				reply("354 Go ahead")
+				data := strings.Builder{}
+				for {
+					dataLine, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if dataLine == ".\r\n" {
+						break
+					}
+					data.WriteString(dataLine)
+				}
+				m.data = data.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				mails <- m
+				return
+			default:
+				reply("502 Unknown command")
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	return &types.SMTPConfig{
+		Name:     "ops",
+		Host:     host,
+		Port:     portNumber,
+		Username: "cheops",
+		Password: "secret",
+		From:     "cheops@example.com",
+	}, mails
+}
+
+func TestEmail(t *testing.T) {
+	server, mails := startSMTPServer(t)
+
+	notifier, err := New(&types.NotifierConfig{
+		Type:   "email",
+		To:     []string{"ops@example.com", "dev@example.com"},
+		Events: []string{types.EventFailure},
+	}, []*types.SMTPConfig{server})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := testEvent(types.EventFailure)
+	event.Build.Steps = []*types.StepRecord{
+		{Name: "clone", Status: types.StatusSuccess},
+		{Name: "build web", Status: types.StatusFailed},
+	}
+	event.Build.Commit.Message = "Add login form"
+	event.Build.Commit.Author = &types.Person{Name: "Octo Cat", Email: "octocat@example.com"}
+	event.LogTail = []string{"Step 1/2 : FROM alpine", "Step 2/2 : RUN false"}
+
+	if err := notifier.Notify(event); err != nil {
+		t.Fatal(err)
+	}
+
+	m := <-mails
+	if !strings.HasPrefix(m.auth, "AUTH PLAIN") {
+		t.Error("Wrong auth:", m.auth)
+	}
+	if m.from != "MAIL FROM:<cheops@example.com>" {
+		t.Error("Wrong sender:", m.from)
+	}
+	if len(m.to) != 2 || m.to[1] != "RCPT TO:<dev@example.com>" {
+		t.Error("Wrong recipients:", m.to)
+	}
+
+	for _, expected := range []string{
+		"To: ops@example.com, dev@example.com\r\n",
+		"Subject: [cheops] https://github.com/a/b.git (main) failed\r\n",
+		"Author: Octo Cat <octocat@example.com>\r\n",
+		"\r\nAdd login form\r\n",
+		"Failed step: build web\r\n",
+		"Error: Boom\r\n",
+		"Step 2/2 : RUN false\r\n",
+	} {
+		if !strings.Contains(m.data, expected) {
+			t.Errorf("Missing %q in:\n%s", expected, m.data)
+		}
+	}
+}
+
+func TestEmailSubject(t *testing.T) {
+	server, mails := startSMTPServer(t)
+
+	notifier, err := New(&types.NotifierConfig{
+		Type:    "email",
+		To:      []string{"ops@example.com"},
+		Subject: "Build of {{.Build.Branch}} ✗",
+		Events:  []string{types.EventFailure},
+	}, []*types.SMTPConfig{server})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := testEvent(types.EventFailure)
+	event.Build.Branch = "main\r\nBcc: eve@example.com"
+	if err := notifier.Notify(event); err != nil {
+		t.Fatal(err)
+	}
+
+	m := <-mails
+	headers := strings.SplitN(m.data, "\r\n\r\n", 2)[0]
+	if !strings.Contains(headers, "Subject: =?utf-8?q?Build_of_main_Bcc:_eve@example.com_=E2=9C=97?=\r\n") ||
+		strings.Contains(headers, "\r\nBcc:") {
+		t.Error("Wrong subject in:\n" + headers)
+	}
+}
+
+func TestEmailStalledServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// Accepts connections but never greets
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			ioutil.ReadAll(conn)
+		}
+	}()
+
+	defer func(timeout time.Duration) { smtpTimeout = timeout }(smtpTimeout)
+	smtpTimeout = 100 * time.Millisecond
+
+	addr := listener.Addr().(*net.TCPAddr)
+	notifier, err := New(&types.NotifierConfig{Type: "email", To: []string{"ops@example.com"}},
+		[]*types.SMTPConfig{{Host: "127.0.0.1", Port: addr.Port, From: "cheops@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- notifier.Notify(testEvent(types.EventFailure))
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected a timeout")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Notify blocked on a stalled server")
+	}
+}
+
+func TestEmailConfig(t *testing.T) {
+	servers := []*types.SMTPConfig{{Name: "a"}, {Name: "b"}}
+
+	if _, err := New(&types.NotifierConfig{Type: "email", To: []string{"x"}}, servers); err == nil {
+		t.Error("Expected an error when the provider is ambiguous")
+	}
+	if _, err := New(&types.NotifierConfig{Type: "email", To: []string{"x"}, Provider: "c"}, servers); err == nil {
+		t.Error("Expected an error for an unknown provider")
+	}
+	if _, err := New(&types.NotifierConfig{Type: "email", Provider: "a"}, servers); err == nil {
+		t.Error("Expected an error without recipients")
+	}
+	if _, err := New(&types.NotifierConfig{Type: "email", To: []string{"x"}, Provider: "b"}, servers); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,103 @@
+package notify
+
+import (
+	"bytes"
+	"cheops/types"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultMessage is used when a notifier doesn't set its own template
+const defaultMessage = `Build {{.Build.ID}} of {{.Build.RepoURL}} ({{.Build.Branch}}@{{.Build.Commit.ID}}` +
+	`{{with .Build.Commit.Author}} by {{.Name}}{{end}}) ` +
+	`{{if eq .Event "start"}}started{{else}}{{.Build.Status}}{{end}}` +
+	`{{if .Build.Error}}: {{.Build.Error}}{{end}}{{if .URL}} {{.URL}}{{end}}`
+
+var defaultEvents = []string{types.EventSuccess, types.EventFailure, types.EventCancelled}
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// New creates the notifier for a build notifier config, servers are the
+// SMTP servers available to email notifiers
+func New(config *types.NotifierConfig, servers []*types.SMTPConfig) (types.Notifier, error) {
+	switch config.Type {
+	case "slack":
+		return newSlack(config)
+
+	case "generic":
+		return newWebhook(config)
+
+	case "email":
+		return newEmail(config, servers)
+
+	default:
+		return nil, errors.New("Unsupported notifier: " + config.Type)
+	}
+}
+
+// parseTemplate parses text, or fallback when text is empty
+func parseTemplate(name, text, fallback string) (*template.Template, error) {
+	if text == "" {
+		text = fallback
+	}
+	return template.New(name).Parse(text)
+}
+
+// Wants reports whether the notifier is triggered by event
+func Wants(config *types.NotifierConfig, event string) bool {
+	events := config.Events
+	if len(events) == 0 {
+		events = defaultEvents
+	}
+
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func render(tmpl *template.Template, event *types.BuildEvent) (string, error) {
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func postJSON(url string, body interface{}, headers map[string]string) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	log.WithFields(log.Fields{
+		"url": url,
+	}).Debug("Sending notification")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return errors.New("Notification failed: " + res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"cheops/types"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type request struct {
+	headers http.Header
+	body    map[string]interface{}
+}
+
+func newTestServer(t *testing.T, status int) (*httptest.Server, chan *request) {
+	requests := make(chan *request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make(map[string]interface{})
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		requests <- &request{r.Header, body}
+		w.WriteHeader(status)
+	}))
+	return server, requests
+}
+
+func testEvent(event string) *types.BuildEvent {
+	return &types.BuildEvent{
+		Event: event,
+		Build: &types.BuildRecord{
+			ID:      "1234",
+			RepoURL: "https://github.com/a/b.git",
+			Branch:  "main",
+			Commit:  &types.CommitInfo{ID: "abc"},
+			Status:  types.StatusFailed,
+			Error:   "Boom",
+		},
+		URL: "https://cheops.io/ui/#build/1234",
+	}
+}
+
+func TestSlack(t *testing.T) {
+	server, requests := newTestServer(t, http.StatusOK)
+	defer server.Close()
+
+	notifier, err := New(&types.NotifierConfig{
+		Type:    "slack",
+		URL:     server.URL,
+		Channel: "#builds",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := notifier.Notify(testEvent(types.EventFailure)); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-requests
+	text := req.body["text"].(string)
+	if text != "Build 1234 of https://github.com/a/b.git (main@abc) failed: Boom https://cheops.io/ui/#build/1234" {
+		t.Error("Wrong text:", text)
+	}
+	if req.body["channel"] != "#builds" {
+		t.Error("Wrong channel:", req.body["channel"])
+	}
+}
+
+func TestGeneric(t *testing.T) {
+	server, requests := newTestServer(t, http.StatusOK)
+	defer server.Close()
+
+	notifier, err := New(&types.NotifierConfig{
+		Type:    "generic",
+		URL:     server.URL,
+		Message: "{{.Event}} {{.Build.Branch}}",
+		Headers: map[string]string{"X-Token": "secret"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := notifier.Notify(testEvent(types.EventStart)); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-requests
+	if req.body["event"] != "start" || req.body["message"] != "start main" {
+		t.Error("Wrong payload:", req.body)
+	}
+	if req.body["build"].(map[string]interface{})["id"] != "1234" {
+		t.Error("Missing build:", req.body)
+	}
+	if req.headers.Get("X-Token") != "secret" {
+		t.Error("Missing header:", req.headers)
+	}
+}
+
+func TestFailedDelivery(t *testing.T) {
+	server, _ := newTestServer(t, http.StatusInternalServerError)
+	defer server.Close()
+
+	notifier, err := New(&types.NotifierConfig{Type: "generic", URL: server.URL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = notifier.Notify(testEvent(types.EventSuccess))
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Error("Expected an error, got", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(&types.NotifierConfig{Type: "carrier-pigeon"}, nil); err == nil {
+		t.Error("Expected an error for an unknown notifier")
+	}
+	if _, err := New(&types.NotifierConfig{Type: "slack"}, nil); err == nil {
+		t.Error("Expected an error for a missing URL")
+	}
+	if _, err := New(&types.NotifierConfig{Type: "slack", URL: "x", Message: "{{"}, nil); err == nil {
+		t.Error("Expected an error for an invalid template")
+	}
+}
+
+func TestWants(t *testing.T) {
+	config := &types.NotifierConfig{}
+	if Wants(config, types.EventStart) || !Wants(config, types.EventFailure) || !Wants(config, types.EventCancelled) {
+		t.Error("Wrong default events")
+	}
+
+	config.Events = []string{types.EventStart}
+	if !Wants(config, types.EventStart) || Wants(config, types.EventSuccess) {
+		t.Error("Wrong configured events")
+	}
+}
@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"cheops/types"
+	"errors"
+	"text/template"
+)
+
+// SlackNotifier posts messages to a Slack incoming webhook
+type SlackNotifier struct {
+	url     string
+	channel string
+	message *template.Template
+}
+
+type slackMessage struct {
+	Text    string `json:"text"`
+	Channel string `json:"channel,omitempty"`
+}
+
+func newSlack(config *types.NotifierConfig) (*SlackNotifier, error) {
+	if config.URL == "" {
+		return nil, errors.New("Slack notifier needs a webhook URL")
+	}
+
+	message, err := parseTemplate("message", config.Message, defaultMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SlackNotifier{
+		url:     config.URL,
+		channel: config.Channel,
+		message: message,
+	}, nil
+}
+
+func (s *SlackNotifier) Notify(event *types.BuildEvent) error {
+	text, err := render(s.message, event)
+	if err != nil {
+		return err
+	}
+
+	return postJSON(s.url, &slackMessage{
+		Text:    text,
+		Channel: s.channel,
+	}, nil)
+}
@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"cheops/types"
+	"errors"
+	"text/template"
+)
+
+// WebhookNotifier posts build events as JSON to any URL
+type WebhookNotifier struct {
+	url     string
+	headers map[string]string
+	message *template.Template
+}
+
+type webhookPayload struct {
+	Event   string             `json:"event"`
+	Message string             `json:"message"`
+	URL     string             `json:"url,omitempty"`
+	Build   *types.BuildRecord `json:"build"`
+}
+
+func newWebhook(config *types.NotifierConfig) (*WebhookNotifier, error) {
+	if config.URL == "" {
+		return nil, errors.New("Generic notifier needs a URL")
+	}
+
+	message, err := parseTemplate("message", config.Message, defaultMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebhookNotifier{
+		url:     config.URL,
+		headers: config.Headers,
+		message: message,
+	}, nil
+}
+
+func (w *WebhookNotifier) Notify(event *types.BuildEvent) error {
+	message, err := render(w.message, event)
+	if err != nil {
+		return err
+	}
+
+	return postJSON(w.url, &webhookPayload{
+		Event:   event.Event,
+		Message: message,
+		URL:     event.URL,
+		Build:   event.Build,
+	}, w.headers)
+}
@@ -0,0 +1,54 @@
+package registry
+
+import (
+	"cheops/docker"
+	"encoding/json"
+	"errors"
+
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// RegistryDockerCredentialsProvider supplies fixed credentials for a
+// registry, such as Docker Hub, GHCR, Harbor or a self-hosted registry
+type RegistryDockerCredentialsProvider struct {
+	host string
+	auth types.AuthConfig
+}
+
+// New creates a provider authenticating either with a username and password
+// or with an identity token. server defaults to Docker Hub.
+func New(server, username, password, identityToken string) (*RegistryDockerCredentialsProvider, error) {
+	log.WithFields(log.Fields{
+		"provider": "registry",
+		"server":   server,
+	}).Debug("Initializing Docker credentials provider")
+
+	if identityToken == "" && (username == "" || password == "") {
+		return nil, errors.New("Must specify a username and password or an identity token")
+	}
+
+	if server == "" {
+		server = docker.ServerAddress(docker.DockerHub)
+	}
+
+	return &RegistryDockerCredentialsProvider{docker.ServerHost(server), types.AuthConfig{
+		Username:      username,
+		Password:      password,
+		IdentityToken: identityToken,
+		ServerAddress: server,
+	}}, nil
+}
+
+func (p *RegistryDockerCredentialsProvider) GetCredentials(registry string) (string, error) {
+	if registry != p.host {
+		return "", errors.New("No credentials for registry: " + registry)
+	}
+
+	bytes, err := json.Marshal(p.auth)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes), nil
+}
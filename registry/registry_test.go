@@ -0,0 +1,56 @@
+package registry
+
+import (
+	"cheops/docker"
+	"encoding/json"
+	"testing"
+)
+
+func TestGetCredentials(t *testing.T) {
+	p, err := New("", "user", "pass", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := p.GetCredentials("docker.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := map[string]string{}
+	if err := json.Unmarshal([]byte(creds), &auth); err != nil {
+		t.Fatal(err)
+	}
+	if auth["username"] != "user" || auth["password"] != "pass" || auth["serveraddress"] != docker.ServerAddress(docker.DockerHub) {
+		t.Error("Wrong credentials:", creds)
+	}
+
+	p, err = New("ghcr.io", "", "", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, _ = p.GetCredentials("ghcr.io")
+	if creds != `{"serveraddress":"ghcr.io","identitytoken":"token"}` {
+		t.Error("Wrong credentials:", creds)
+	}
+}
+
+func TestOtherRegistry(t *testing.T) {
+	p, err := New("https://ghcr.io/v2/", "user", "pass", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.GetCredentials("ghcr.io"); err != nil {
+		t.Error(err)
+	}
+	if _, err := p.GetCredentials("docker.io"); err == nil {
+		t.Error("Expected an error for another registry")
+	}
+}
+
+func TestMissingCredentials(t *testing.T) {
+	if _, err := New("ghcr.io", "user", "", ""); err == nil {
+		t.Error("Expected an error without a password")
+	}
+}
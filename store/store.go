@@ -0,0 +1,297 @@
+package store
+
+import (
+	"cheops/types"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// collection is a bucket of JSON documents keyed by ID, along with an index
+// bucket mapping creation time + ID to the ID, so documents can be walked in
+// chronological order
+type collection struct {
+	data  []byte
+	index []byte
+}
+
+var (
+	builds     = collection{[]byte("builds"), []byte("builds_by_time")}
+	deliveries = collection{[]byte("deliveries"), []byte("deliveries_by_time")}
+)
+
+var (
+	// ErrNotFound is returned when a build doesn't exist in the store
+	ErrNotFound = errors.New("Build not found")
+	// ErrDeliveryNotFound is returned when a webhook delivery doesn't exist
+	// in the store
+	ErrDeliveryNotFound = errors.New("Delivery not found")
+)
+
+// BoltStore is a BuildStore backed by a BoltDB file
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func New(path string) (*BoltStore, error) {
+	log.WithFields(log.Fields{
+		"path": path,
+	}).Debug("Opening build store")
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, c := range []collection{builds, deliveries} {
+			if _, err := tx.CreateBucketIfNotExists(c.data); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucketIfNotExists(c.index); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db}, nil
+}
+
+func indexKey(id string, createdAt time.Time) []byte {
+	key := make([]byte, 8, 8+len(id))
+	binary.BigEndian.PutUint64(key, uint64(createdAt.UnixNano()))
+	return append(key, id...)
+}
+
+func (s *BoltStore) put(c collection, id string, createdAt time.Time, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(c.data).Put([]byte(id), data)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(c.index).Put(indexKey(id, createdAt), []byte(id))
+	})
+}
+
+// get unmarshals the document into value, it returns notFound if the
+// document doesn't exist
+func (s *BoltStore) get(c collection, id string, value interface{}, notFound error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(c.data).Get([]byte(id))
+		if data == nil {
+			return notFound
+		}
+		return json.Unmarshal(data, value)
+	})
+}
+
+// walk calls fn with every document, most recent first, until it returns
+// false
+func (s *BoltStore) walk(c collection, fn func(data []byte) (bool, error)) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(c.data)
+		cursor := tx.Bucket(c.index).Cursor()
+
+		for _, id := cursor.Last(); id != nil; _, id = cursor.Prev() {
+			data := bucket.Get(id)
+			if data == nil {
+				continue
+			}
+
+			more, err := fn(data)
+			if err != nil {
+				return err
+			}
+			if !more {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// prune deletes the documents outside the retention policy, except those
+// keep returns true for, and returns their IDs
+func prune(tx *bolt.Tx, c collection, policy *types.HistoryConfig, keep func(data []byte) (bool, error)) ([]string, error) {
+	bucket := tx.Bucket(c.data)
+	index := tx.Bucket(c.index)
+
+	var cutoff uint64
+	if policy.MaxAge > 0 {
+		cutoff = uint64(time.Now().Add(-policy.MaxAge).UnixNano())
+	}
+
+	count := 0
+	cursor := index.Cursor()
+	for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+		count++
+	}
+
+	// The keys are deleted once the cursor is done with the index
+	keys := [][]byte{}
+	pruned := []string{}
+	for key, id := cursor.First(); key != nil; key, id = cursor.Next() {
+		tooMany := policy.MaxBuilds > 0 && count > policy.MaxBuilds
+		tooOld := binary.BigEndian.Uint64(key[:8]) < cutoff
+		if !tooMany && !tooOld {
+			break
+		}
+
+		if kept, err := keep(bucket.Get(id)); err != nil {
+			return nil, err
+		} else if kept {
+			continue
+		}
+
+		keys = append(keys, append([]byte{}, key...))
+		pruned = append(pruned, string(id))
+		count--
+	}
+
+	for i, key := range keys {
+		if err := bucket.Delete([]byte(pruned[i])); err != nil {
+			return nil, err
+		}
+		if err := index.Delete(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return pruned, nil
+}
+
+// buildInProgress tells whether a build record is pending or running, and
+// mustn't be pruned
+func buildInProgress(data []byte) (bool, error) {
+	if data == nil {
+		return false, nil
+	}
+
+	record := &types.BuildRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return false, err
+	}
+	return record.Status == types.StatusPending || record.Status == types.StatusRunning, nil
+}
+
+func keepNothing(data []byte) (bool, error) {
+	return false, nil
+}
+
+func (s *BoltStore) SaveBuild(record *types.BuildRecord) error {
+	return s.put(builds, record.ID, record.CreatedAt, record)
+}
+
+func (s *BoltStore) GetBuild(id string) (*types.BuildRecord, error) {
+	record := &types.BuildRecord{}
+	if err := s.get(builds, id, record, ErrNotFound); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func matchesFilter(record *types.BuildRecord, filter *types.BuildFilter) bool {
+	if filter.RepoURL != "" && filter.RepoURL != record.RepoURL {
+		return false
+	}
+	if filter.Branch != "" && filter.Branch != record.Branch {
+		return false
+	}
+	if filter.Status != "" && filter.Status != record.Status {
+		return false
+	}
+	return true
+}
+
+func (s *BoltStore) ListBuilds(filter *types.BuildFilter) ([]*types.BuildRecord, error) {
+	if filter == nil {
+		filter = &types.BuildFilter{}
+	}
+
+	records := []*types.BuildRecord{}
+	err := s.walk(builds, func(data []byte) (bool, error) {
+		record := &types.BuildRecord{}
+		if err := json.Unmarshal(data, record); err != nil {
+			return false, err
+		}
+
+		if matchesFilter(record, filter) {
+			records = append(records, record)
+		}
+		return filter.Limit <= 0 || len(records) < filter.Limit, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (s *BoltStore) SaveDelivery(delivery *types.Delivery) error {
+	return s.put(deliveries, delivery.ID, delivery.ReceivedAt, delivery)
+}
+
+func (s *BoltStore) GetDelivery(id string) (*types.Delivery, error) {
+	delivery := &types.Delivery{}
+	if err := s.get(deliveries, id, delivery, ErrDeliveryNotFound); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func (s *BoltStore) ListDeliveries(limit int) ([]*types.Delivery, error) {
+	list := []*types.Delivery{}
+	err := s.walk(deliveries, func(data []byte) (bool, error) {
+		delivery := &types.Delivery{}
+		if err := json.Unmarshal(data, delivery); err != nil {
+			return false, err
+		}
+
+		list = append(list, delivery)
+		return limit <= 0 || len(list) < limit, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Prune applies the retention policy to both builds and webhook deliveries,
+// keeping the builds still in progress
+func (s *BoltStore) Prune(policy *types.HistoryConfig) ([]string, error) {
+	var pruned []string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		pruned, err = prune(tx, builds, policy, buildInProgress)
+		if err != nil {
+			return err
+		}
+
+		_, err = prune(tx, deliveries, policy, keepNothing)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pruned, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"cheops/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) (*BoltStore, func()) {
+	dir, err := ioutil.TempDir("", "cheops-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(filepath.Join(dir, "cheops.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func saveTestBuild(t *testing.T, s *BoltStore, id, branch string, createdAt time.Time) {
+	err := s.SaveBuild(&types.BuildRecord{
+		ID:        id,
+		RepoURL:   "https://github.com/a/b.git",
+		Branch:    branch,
+		Commit:    &types.CommitInfo{ID: "abc", Branch: branch},
+		Status:    types.StatusSuccess,
+		CreatedAt: createdAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveAndGetBuild(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	now := time.Now()
+	saveTestBuild(t, s, "1", "main", now)
+
+	record, err := s.GetBuild("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Branch != "main" || record.Commit.ID != "abc" || !record.CreatedAt.Equal(now) {
+		t.Error("Wrong record:", record)
+	}
+
+	if _, err := s.GetBuild("2"); err != ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+}
+
+func TestListBuilds(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	now := time.Now()
+	saveTestBuild(t, s, "b", "main", now.Add(-time.Hour))
+	saveTestBuild(t, s, "a", "develop", now.Add(-time.Minute))
+	saveTestBuild(t, s, "c", "main", now)
+	// Saving again doesn't duplicate the build
+	saveTestBuild(t, s, "c", "main", now)
+
+	records, err := s.ListBuilds(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].ID != "c" || records[1].ID != "a" || records[2].ID != "b" {
+		t.Error("Wrong order:", records)
+	}
+
+	records, err = s.ListBuilds(&types.BuildFilter{Branch: "main", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID != "c" {
+		t.Error("Wrong filtering:", records)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	now := time.Now()
+	saveTestBuild(t, s, "1", "main", now.Add(-48*time.Hour))
+	saveTestBuild(t, s, "2", "main", now.Add(-3*time.Hour))
+	saveTestBuild(t, s, "3", "main", now.Add(-2*time.Hour))
+	saveTestBuild(t, s, "4", "main", now.Add(-time.Hour))
+
+	pruned, err := s.Prune(&types.HistoryConfig{MaxAge: 24 * time.Hour})
+	if err != nil || len(pruned) != 1 || pruned[0] != "1" {
+		t.Fatal("Expected build 1 to be pruned, got", pruned, err)
+	}
+
+	pruned, err = s.Prune(&types.HistoryConfig{MaxBuilds: 2})
+	if err != nil || len(pruned) != 1 || pruned[0] != "2" {
+		t.Fatal("Expected build 2 to be pruned, got", pruned, err)
+	}
+
+	records, _ := s.ListBuilds(nil)
+	if len(records) != 2 || records[0].ID != "4" || records[1].ID != "3" {
+		t.Error("Wrong remaining builds:", records)
+	}
+}
+
+func TestPruneInProgress(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	now := time.Now()
+	saveTestBuild(t, s, "1", "main", now.Add(-48*time.Hour))
+	saveTestBuild(t, s, "2", "main", now.Add(-3*time.Hour))
+	saveTestBuild(t, s, "3", "main", now.Add(-2*time.Hour))
+	for _, id := range []string{"1", "2"} {
+		record, _ := s.GetBuild(id)
+		record.Status = types.StatusRunning
+		if id == "2" {
+			record.Status = types.StatusPending
+		}
+		if err := s.SaveBuild(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruned, err := s.Prune(&types.HistoryConfig{MaxAge: time.Hour, MaxBuilds: 1})
+	if err != nil || len(pruned) != 1 || pruned[0] != "3" {
+		t.Fatal("Expected build 3 to be pruned, got", pruned, err)
+	}
+
+	records, _ := s.ListBuilds(nil)
+	if len(records) != 2 || records[0].ID != "2" || records[1].ID != "1" {
+		t.Error("Wrong remaining builds:", records)
+	}
+}
+
+func TestDeliveries(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	now := time.Now()
+	for i, id := range []string{"1", "2", "3"} {
+		err := s.SaveDelivery(&types.Delivery{
+			ID:         id,
+			Endpoint:   "/github",
+			Headers:    map[string][]string{"X-Github-Event": {"push"}},
+			Payload:    []byte(`{"ref":"refs/heads/main"}`),
+			ReceivedAt: now.Add(time.Duration(i) * time.Minute),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	delivery, err := s.GetDelivery("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(delivery.Payload) != `{"ref":"refs/heads/main"}` || delivery.Headers["X-Github-Event"][0] != "push" {
+		t.Error("Wrong delivery:", delivery)
+	}
+
+	if _, err := s.GetDelivery("4"); err != ErrDeliveryNotFound {
+		t.Error("Expected ErrDeliveryNotFound, got", err)
+	}
+
+	list, err := s.ListDeliveries(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ID != "3" || list[1].ID != "2" {
+		t.Error("Wrong deliveries:", list)
+	}
+
+	if _, err := s.Prune(&types.HistoryConfig{MaxBuilds: 1}); err != nil {
+		t.Fatal(err)
+	}
+	list, _ = s.ListDeliveries(0)
+	if len(list) != 1 || list[0].ID != "3" {
+		t.Error("Wrong deliveries after pruning:", list)
+	}
+}
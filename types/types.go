@@ -1,7 +1,9 @@
 package types
 
 import (
+	"context"
 	"io"
+	"time"
 )
 
 type GeneralConfig struct {
@@ -9,6 +11,48 @@ type GeneralConfig struct {
 	TLSCert    string `yaml:"tls_cert"`
 	TLSKey     string `yaml:"tls_key"`
 	BindAddr   string `yaml:"bind_addr"`
+	// APIToken authenticates requests to the REST API, which rejects every
+	// request when it is empty
+	APIToken string `yaml:"api_token"`
+	// DataDir holds the build history database, defaults to "data"
+	DataDir string `yaml:"data_dir"`
+	History HistoryConfig
+	// Engine is the container engine builds run on, docker (default) or
+	// podman
+	Engine string
+	Docker DockerConfig
+	Podman PodmanConfig
+}
+
+// DockerConfig is the Docker daemon builds run on, when Host is empty the
+// environment is used (DOCKER_HOST, DOCKER_CERT_PATH, DOCKER_TLS_VERIFY and
+// DOCKER_API_VERSION)
+type DockerConfig struct {
+	Host       string
+	APIVersion string `yaml:"api_version"`
+	TLSCA      string `yaml:"tls_ca"`
+	TLSCert    string `yaml:"tls_cert"`
+	TLSKey     string `yaml:"tls_key"`
+	TLSVerify  bool   `yaml:"tls_verify"`
+	// Builder is the docker buildx builder of BuildKit builds, exporting
+	// caches needs one with the docker-container driver
+	Builder string
+}
+
+// PodmanConfig is the Podman service builds run on through its Docker
+// compatible API. Socket is a path or a unix:// or tcp:// URL, it defaults to
+// CONTAINER_HOST, then to the socket of the rootless service of the user or
+// /run/podman/podman.sock when running as root.
+type PodmanConfig struct {
+	Socket     string
+	APIVersion string `yaml:"api_version"`
+}
+
+// HistoryConfig is the retention policy of the build history, a zero value
+// disables the corresponding limit
+type HistoryConfig struct {
+	MaxBuilds int           `yaml:"max_builds"`
+	MaxAge    time.Duration `yaml:"max_age"`
 }
 
 type GitProviderConfig struct {
@@ -27,11 +71,90 @@ type DockerCredsProviderConfig struct {
 	AwsAccessKeyID     string `yaml:"aws_access_key_id"`
 	AwsSecretAccessKey string `yaml:"aws_secret_access_key"`
 	AwsSessionToken    string `yaml:"aws_session_token"`
+	// Without static keys, the aws provider uses the standard credential
+	// chain, optionally with a shared config profile. AwsRoleARN is assumed
+	// before calling ECR and AwsRegistryIDs are the accounts to request
+	// tokens for, e.g. for cross-account pushes.
+	AwsProfile     string   `yaml:"aws_profile"`
+	AwsRoleARN     string   `yaml:"aws_role_arn"`
+	AwsExternalID  string   `yaml:"aws_external_id"`
+	AwsRegistryIDs []string `yaml:"aws_registry_ids"`
+	AwsEndpoint    string   `yaml:"aws_endpoint"`
+	AwsSTSEndpoint string   `yaml:"aws_sts_endpoint"`
+	// AwsCreateRepositories creates missing ECR repositories on push
+	AwsCreateRepositories bool   `yaml:"aws_create_repositories"`
+	AwsImageTagMutability string `yaml:"aws_image_tag_mutability"`
+	AwsScanOnPush         bool   `yaml:"aws_scan_on_push"`
+	AwsLifecyclePolicy    string `yaml:"aws_lifecycle_policy"`
+	// Registry, Username, Password and IdentityToken configure the
+	// registry provider. Secrets can also be read from an environment
+	// variable or a file.
+	Registry          string `yaml:"registry"`
+	Username          string `yaml:"username"`
+	Password          string `yaml:"password"`
+	PasswordEnv       string `yaml:"password_env"`
+	PasswordFile      string `yaml:"password_file"`
+	IdentityToken     string `yaml:"identity_token"`
+	IdentityTokenEnv  string `yaml:"identity_token_env"`
+	IdentityTokenFile string `yaml:"identity_token_file"`
+	// DockerConfig is the config.json read by the dockerconfig provider,
+	// defaults to the one of the Docker CLI
+	DockerConfig string `yaml:"docker_config"`
+	// GcpKey is the service account JSON key of the gcp provider,
+	// GcpTokenURL overrides its token endpoint
+	GcpKey      string `yaml:"gcp_key"`
+	GcpKeyEnv   string `yaml:"gcp_key_env"`
+	GcpKeyFile  string `yaml:"gcp_key_file"`
+	GcpTokenURL string `yaml:"gcp_token_url"`
+	// The azure provider uses the admin user of Registry from Username and
+	// Password, or the client credentials of a service principal.
+	// AzureAuthority and AzureRegistryEndpoint override the Azure AD and
+	// registry URLs.
+	AzureTenantID         string `yaml:"azure_tenant_id"`
+	AzureClientID         string `yaml:"azure_client_id"`
+	AzureClientSecret     string `yaml:"azure_client_secret"`
+	AzureClientSecretEnv  string `yaml:"azure_client_secret_env"`
+	AzureClientSecretFile string `yaml:"azure_client_secret_file"`
+	AzureAuthority        string `yaml:"azure_authority"`
+	AzureRegistryEndpoint string `yaml:"azure_registry_endpoint"`
+}
+
+// SMTPConfig is a mail server used by email notifiers
+type SMTPConfig struct {
+	Name     string
+	Host     string
+	Port     int
+	StartTLS bool `yaml:"starttls"`
+	Username string
+	Password string
+	From     string
+}
+
+// StorageProviderConfig is a store upload actions upload files to. The s3
+// type uses the AWS credentials as the aws Docker credentials provider,
+// S3Endpoint and S3PathStyle configure S3 compatible stores such as MinIO.
+type StorageProviderConfig struct {
+	Name                   string
+	Type                   string
+	AwsRegion              string `yaml:"aws_region"`
+	AwsAccessKeyID         string `yaml:"aws_access_key_id"`
+	AwsSecretAccessKey     string `yaml:"aws_secret_access_key"`
+	AwsSecretAccessKeyEnv  string `yaml:"aws_secret_access_key_env"`
+	AwsSecretAccessKeyFile string `yaml:"aws_secret_access_key_file"`
+	AwsSessionToken        string `yaml:"aws_session_token"`
+	AwsProfile             string `yaml:"aws_profile"`
+	AwsRoleARN             string `yaml:"aws_role_arn"`
+	AwsExternalID          string `yaml:"aws_external_id"`
+	AwsSTSEndpoint         string `yaml:"aws_sts_endpoint"`
+	S3Endpoint             string `yaml:"s3_endpoint"`
+	S3PathStyle            bool   `yaml:"s3_path_style"`
 }
 
 type ProvidersConfig struct {
 	Git         []*GitProviderConfig
 	DockerCreds []*DockerCredsProviderConfig `yaml:"docker_creds"`
+	SMTP        []*SMTPConfig                `yaml:"smtp"`
+	Storage     []*StorageProviderConfig     `yaml:"storage"`
 }
 
 type CheopsConfig struct {
@@ -40,21 +163,113 @@ type CheopsConfig struct {
 	Providers ProvidersConfig
 }
 
+// Repository is a server-side repository entry. The same URL may appear in
+// several entries, each one matching a different set of branches (and
+// holding different secrets); the first entry that matches a push is used.
 type Repository struct {
 	Provider string
 	URL      string
 	Branch   string
+	// Branches is a list of branch names or glob patterns (as understood by
+	// path.Match), used together with Branch.
+	Branches []string
 	Secrets  map[string]interface{}
+	// SSH are the SSH agent sockets or private keys the builds of the
+	// entry may forward, keyed by the IDs containers name them by. An empty
+	// value forwards the agent of SSH_AUTH_SOCK.
+	SSH map[string]string
+	// SkipDirectives are the strings that, found in the head commit message
+	// of a push, skip the build. Defaults to [skip ci] and [ci skip], an
+	// empty list disables skipping.
+	SkipDirectives []string `yaml:"skip_directives"`
 }
 
 // DockerCredsProvider provides credentials for pushing Docker images
 type DockerCredsProvider interface {
-	GetCredentials() (string, error)
+	// GetCredentials returns the auth config JSON for the registry host of
+	// the image, as returned by docker.RegistryHost
+	GetCredentials(registry string) (string, error)
+}
+
+// BuildOptions describe an image build
+type BuildOptions struct {
+	ContextDir string
+	// Dockerfile is relative to ContextDir
+	Dockerfile string
+	Tags       []string
+	Args       map[string]*string
+	// Credentials are used to pull the base images, keyed by registry host
+	Credentials map[string]string
+	// BuildKit and the following options are those of Container, with
+	// Secrets holding the values keyed by ID
+	// SSH are docker build --ssh specs (<id> or <id>=<path>), and the local
+	// caches are confined to ContextDir
+	BuildKit  bool
+	Secrets   map[string]string
+	SSH       []string
+	CacheFrom []string
+	CacheTo   []string
+	// Platform is the os/arch[/variant] to build for, the one of the daemon
+	// when empty
+	Platform string
+}
+
+// RunOptions describe a container run by an exec action
+type RunOptions struct {
+	Image    string
+	Commands []string
+	Env      []string
+	// Credentials are used to pull the image if it's missing
+	Credentials string
+	// Artifacts are the paths passed to CopyArtifact, as tar archives, once
+	// the commands succeed
+	Artifacts    []string
+	CopyArtifact func(path string, archive io.Reader) error
+}
+
+// Engine builds, pushes and runs container images
+type Engine interface {
+	// BuildImage builds an image and returns its ID
+	BuildImage(ctx context.Context, options *BuildOptions, out io.Writer) (string, error)
+	// PushImage pushes an image and returns the digest of its manifest
+	PushImage(ctx context.Context, image, credentials string, out io.Writer) (string, error)
+	// PushManifestList pushes a manifest list of pushed manifests, referenced
+	// by digest, and returns its digest
+	PushManifestList(ctx context.Context, image string, manifests []string, credentials string, out io.Writer) (string, error)
+	TagImage(ctx context.Context, source, target string) error
+	// CopyFromImage returns a tar archive of a path of an image
+	CopyFromImage(ctx context.Context, image, path string) (io.ReadCloser, error)
+	// RunContainer runs the commands of a container, which fail when they
+	// exit with a non-zero status
+	RunContainer(ctx context.Context, options *RunOptions, out io.Writer) error
+	RemoveImage(ctx context.Context, image string) error
+}
+
+// UploadFile is the destination of a file uploaded by an upload action
+type UploadFile struct {
+	Bucket      string
+	Key         string
+	ContentType string
+	// Public makes the file readable by anyone
+	Public bool
+}
+
+// StorageProvider stores files uploaded by builds
+type StorageProvider interface {
+	// Upload uploads a file and returns its URL
+	Upload(ctx context.Context, file *UploadFile, body io.Reader) (string, error)
+}
+
+// RepositoryCreator is implemented by the Docker credentials providers that
+// can create the repository of an image before it's pushed
+type RepositoryCreator interface {
+	EnsureRepository(image string) error
 }
 
 // GitProvider provides cloning access to a repository
 type GitProvider interface {
-	Clone(commit *CommitInfo, targetDir string) error
+	Clone(commit *CommitInfo, targetDir string, progress io.Writer) error
+	ResolveBranch(repoURL, branch string) (string, error)
 	RegisterRepo(repo *Repository) error
 }
 
@@ -66,18 +281,71 @@ type Cheops interface {
 }
 
 type Container struct {
+	// Name is how push actions reference the container, defaults to Tag
+	Name       string
 	Dockerfile string
 	Context    string
 	Tag        string
-	Args       map[string]*string
+	// Tags are additional tags of the image
+	Tags []string
+	Args map[string]*string
+	// BuildKit builds the container with docker buildx, enabling RUN
+	// --mount, Secrets and SSH
+	BuildKit bool `yaml:"buildkit"`
+	// Secrets are the repository secrets exposed to RUN
+	// --mount=type=secret,id=<name>
+	Secrets []string
+	// SSH are the IDs of the SSH sources of the repository entry forwarded
+	// to RUN --mount=type=ssh,id=<id>
+	SSH []string
+	// CacheFrom and CacheTo are the cache imports and exports, as in docker
+	// buildx (type=registry,ref=<image> or type=local,src=<dir>). The
+	// directories of local caches are relative to the workspace.
+	CacheFrom []string `yaml:"cache_from"`
+	CacheTo   []string `yaml:"cache_to"`
+	// Platforms are built with BuildKit, each one tagged <tag>-<os>-<arch>
+	// locally, and push actions push them by digest with a manifest list
+	// under the tags
+	Platforms []string
+	// Artifacts are the paths copied out of the built image, of the first
+	// platform for multi-platform images
+	Artifacts []string
 }
 
 type Action struct {
-	Type      string
-	Commands  []string
-	Image     string
-	Provider  string
-	Container string
+	Type     string
+	Commands []string
+	Image    string
+	Provider string
+	// Container is the built container a push action pushes all the tags
+	// of, to its own repository or to each of Registries
+	Container  string
+	Registries []*PushRegistry
+	// Artifacts are the paths an exec action copies out of its container
+	// once the commands succeed. For upload actions, they are path.Match
+	// patterns of the build artifacts to upload.
+	Artifacts []string
+	// Files are filepath.Match patterns of the files and directories of the
+	// workspace an upload action uploads to Bucket, with the storage
+	// Provider
+	Files  []string
+	Bucket string
+	// Key is the key of uploaded files, where {repo}, {branch}, {commit},
+	// {build}, {path} and {name} are replaced, defaults to
+	// {repo}/{branch}/{commit}/{path}
+	Key string
+	// ContentType overrides the content type guessed from the file
+	// extensions
+	ContentType string `yaml:"content_type"`
+	// Public uploads the files with a public-read ACL
+	Public bool
+}
+
+// PushRegistry is a repository a container is pushed to, with the
+// credentials provider to push with, defaulting to the one of the action
+type PushRegistry struct {
+	Repository string
+	Provider   string
 }
 
 type Build struct {
@@ -85,25 +353,214 @@ type Build struct {
 	Branch     string
 	Containers []*Container
 	Actions    []*Action
-	Notifiers  []*Notifier
+	Notifiers  []*NotifierConfig
+	// PullCreds are the Docker credentials providers used to pull base
+	// images and the images of exec actions, tried in order
+	PullCreds []string `yaml:"pull_creds"`
 }
 
 type BuildsConfig struct {
 	Builds []*Build
 }
 
-type Notifier struct{}
+// NotifierConfig is a notifier declared in a build
+type NotifierConfig struct {
+	Type string
+	URL  string
+	// Events that trigger the notifier, defaults to success, failure and
+	// cancelled
+	Events []string
+	// Message is a text/template rendered with the BuildEvent. cheops.yaml
+	// is a template itself, so it's written as a string literal, e.g.
+	// message: {{quote "Build {{.Build.ID}} {{.Build.Status}}"}}
+	Message string
+	// Channel overrides the default channel of a Slack webhook
+	Channel string
+	// Headers are added to the requests of generic webhooks
+	Headers map[string]string
+	// Provider is the SMTP server of email notifiers, it can be omitted
+	// when there's only one
+	Provider string
+	// To and Subject are used by email notifiers, Subject is a
+	// text/template written as a string literal like Message
+	To      []string
+	Subject string
+}
 
-type CommitInfo struct {
-	ID      string
-	Branch  string
+// Build events sent to notifiers
+const (
+	EventStart     = "start"
+	EventSuccess   = "success"
+	EventFailure   = "failure"
+	EventCancelled = "cancelled"
+)
+
+// BuildEvent describes a change in the state of a build
+type BuildEvent struct {
+	Event string
+	Build *BuildRecord
+	// URL of the build in the dashboard
+	URL string
+	// LogTail holds the last lines of the build log of failed builds
+	LogTail []string
+}
+
+// Notifier sends build events to an external service
+type Notifier interface {
+	Notify(event *BuildEvent) error
+}
+
+// Build and step statuses
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSuccess   = "success"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+	StatusSkipped   = "skipped"
+)
+
+type StepRecord struct {
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// BuildRecord is the persisted state of a build
+type BuildRecord struct {
+	ID         string            `json:"id"`
+	RepoURL    string            `json:"repo_url"`
+	Branch     string            `json:"branch"`
+	Commit     *CommitInfo       `json:"commit"`
+	Params     map[string]string `json:"params,omitempty"`
+	Build      string            `json:"build,omitempty"`
+	Status     string            `json:"status"`
+	Steps      []*StepRecord     `json:"steps"`
+	CreatedAt  time.Time         `json:"created_at"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Error      string            `json:"error,omitempty"`
+	// RerunOf is the ID of the build this one re-runs
+	RerunOf string `json:"rerun_of,omitempty"`
+	// DeliveryID is the webhook delivery that triggered the build
+	DeliveryID string `json:"delivery_id,omitempty"`
+	// SkippedBy is the commit message directive that skipped the build
+	SkippedBy string `json:"skipped_by,omitempty"`
+	// Images are the images pushed by the build
+	Images []*ImageRecord `json:"images,omitempty"`
+	// Artifacts are the files exported by the build
+	Artifacts []*ArtifactRecord `json:"artifacts,omitempty"`
+}
+
+// ImageRecord is an image pushed by a build
+type ImageRecord struct {
+	Image  string `json:"image"`
+	Digest string `json:"digest,omitempty"`
+}
+
+// ArtifactRecord is a file exported by a build, Path is relative to the
+// artifacts of the build
+type ArtifactRecord struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Step is the step that exported the file
+	Step string `json:"step,omitempty"`
+}
+
+// Delivery is a webhook request as received from a Git provider
+type Delivery struct {
+	ID         string              `json:"id"`
+	Endpoint   string              `json:"endpoint"`
+	Headers    map[string][]string `json:"headers"`
+	Payload    []byte              `json:"payload"`
+	ReceivedAt time.Time           `json:"received_at"`
+	Commit     *CommitInfo         `json:"commit,omitempty"`
+	BuildID    string              `json:"build_id,omitempty"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// BuildFilter narrows down the builds returned by BuildStore.ListBuilds,
+// empty fields match everything
+type BuildFilter struct {
 	RepoURL string
+	Branch  string
+	Status  string
+	Limit   int
+}
+
+// BuildStore persists the build history
+type BuildStore interface {
+	SaveBuild(record *BuildRecord) error
+	GetBuild(id string) (*BuildRecord, error)
+	// ListBuilds returns the matching builds, most recent first
+	ListBuilds(filter *BuildFilter) ([]*BuildRecord, error)
+	SaveDelivery(delivery *Delivery) error
+	GetDelivery(id string) (*Delivery, error)
+	// ListDeliveries returns the latest deliveries, most recent first
+	ListDeliveries(limit int) ([]*Delivery, error)
+	// Prune deletes the finished builds and the deliveries that fall
+	// outside the retention policy and returns the IDs of the deleted builds
+	Prune(policy *HistoryConfig) ([]string, error)
+	Close() error
+}
+
+// Person is a commit author or the user who pushed
+type Person struct {
+	Name     string `json:"name"`
+	Email    string `json:"email,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+// Commit describes a single commit of a push
+type Commit struct {
+	ID        string    `json:"id"`
+	Message   string    `json:"message"`
+	Author    *Person   `json:"author,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	URL       string    `json:"url,omitempty"`
+	Added     []string  `json:"added,omitempty"`
+	Removed   []string  `json:"removed,omitempty"`
+	Modified  []string  `json:"modified,omitempty"`
+}
+
+// CommitInfo is the commit being built. The details beyond ID, Branch and
+// RepoURL are filled from the webhook when the provider has them, and from
+// the cloned repository otherwise. cheops.yaml templates see it as
+// .CommitInfo, its free text must be inserted with quote.
+type CommitInfo struct {
+	ID         string    `json:"id"`
+	Branch     string    `json:"branch"`
+	RepoURL    string    `json:"repo_url"`
+	Message    string    `json:"message,omitempty"`
+	Author     *Person   `json:"author,omitempty"`
+	Pusher     *Person   `json:"pusher,omitempty"`
+	CompareURL string    `json:"compare_url,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	// Commits lists the commits of the push, oldest first
+	Commits []*Commit `json:"commits,omitempty"`
+}
+
+// BuildLog captures the output of a build, tagged by step
+type BuildLog interface {
+	Step(name string) io.Writer
 }
 
 type BuildContext struct {
+	// Context is cancelled when the build is cancelled
+	Context context.Context
+	ID      string
 	Build   *Build
 	Commit  *CommitInfo
+	Params  map[string]string
 	RepoDir string
+	Record  *BuildRecord
+	Log     BuildLog
+	// Secrets are the secrets of the repository entry
+	Secrets map[string]interface{}
+	// SSH are the SSH sources of the repository entry
+	SSH map[string]string
 }
 
 type WebhookFunc func(body io.ReadCloser, headers map[string][]string) (*CommitInfo, error)